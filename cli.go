@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"ldap-itop/config"
+	"ldap-itop/parser"
+	"ldap-itop/synchronizer"
+)
+
+// command is one subcommand of the binary. run receives the arguments after the
+// command name and the options given before it. needs are the settings validated
+// before it runs.
+type command struct {
+	name  string
+	usage string
+	run   func(cfg *config.Config, opts runOptions, args []string) error
+	needs config.Needs
+}
+
+var commands = []command{
+	{"run", "Run the whole pipeline (default when no command is given)", cmdRun, config.NeedLDAP | config.NeedITop | config.NeedMail},
+	{"serve", "Run the pipeline on a schedule until stopped", cmdServe, config.NeedAll},
+	{"export-users", "Read LDAP and write the users to a CSV", cmdExportUsers, config.NeedLDAP},
+	{"validate-departments", "Assign a valid department to the users of a CSV", cmdValidateDepartments, 0},
+	// Needs LDAP only without --in, checked once the flags are parsed
+	{"compare-matchers", "Report how every matching strategy would classify the users", cmdCompareMatchers, 0},
+	{"approve-departments", "Add the approved rows of the validation error report as department aliases", cmdApproveDepartments, 0},
+	{"sync-teams", "Create missing iTop Teams for the department list", cmdSyncTeams, config.NeedITop},
+	{"sync-users", "Add the users of a validated CSV to their iTop Team", cmdSyncUsers, config.NeedITop},
+	{"report", "Email the error reports of a previous run", cmdReport, config.NeedMail},
+	{"config", "\"config validate\" checks the configuration and lists every problem", nil, config.NeedAll},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [--config file] [--dry-run] [--full-sync] <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-22s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(out, "\nRun \"%s <command> -h\" for the flags of a command.\n\nGlobal flags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name && c.run != nil {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
+
+func cmdRun(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("run")
+	dryRun := fs.Bool("dry-run", opts.DryRun, "Only write a plan report instead of changing iTop or the department YAML")
+	fullSync := fs.Bool("full-sync", opts.FullSync, "Ignore the incremental sync state and read every user")
+	fs.Parse(args)
+	return runSync(context.Background(), cfg, runOptions{DryRun: *dryRun, FullSync: *fullSync})
+}
+
+func cmdServe(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("serve")
+	dryRun := fs.Bool("dry-run", opts.DryRun, "Only write a plan report on every run")
+	fs.Parse(args)
+	opts.DryRun = *dryRun
+	return serve(cfg, opts)
+}
+
+func cmdExportUsers(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("export-users")
+	out := fs.String("out", cfg.Paths.Output("ldap-users.csv"), "CSV file to write")
+	fs.Parse(args)
+
+	// A stage run always reads every user; the incremental state is only kept by "run"
+	export, err := exportUsers(cfg, true)
+	if err != nil {
+		return err
+	}
+	if err := parser.SaveUsersToCSV(export.Users, *out); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	log.Printf("[OK] %d user(s) written to %s", len(export.Users), *out)
+	return nil
+}
+
+func cmdValidateDepartments(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("validate-departments")
+	in := fs.String("in", cfg.Paths.Output("ldap-users.csv"), "Users CSV written by export-users")
+	departments := fs.String("departments", cfg.Paths.DepartmentYAML, "Department list YAML")
+	out := fs.String("out", cfg.Paths.Output("users.csv"), "Users CSV with the valid department")
+	report := fs.String("report", cfg.Paths.Output("dept-validation-errors-report.csv"), "Validation error report")
+	fs.Parse(args)
+
+	users, err := parser.LoadUsersFromCSV(*in)
+	if err != nil {
+		return err
+	}
+	return validateDepartments(cfg, users, *departments, *out, *report)
+}
+
+func cmdCompareMatchers(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("compare-matchers")
+	in := fs.String("in", "", "Users CSV written by export-users (default: read LDAP)")
+	departments := fs.String("departments", cfg.Paths.DepartmentYAML, "Department list YAML")
+	out := fs.String("out", cfg.Paths.Output("matcher-comparison.csv"), "Comparison report")
+	fs.Parse(args)
+
+	matchers, err := cfg.Matching.Matchers()
+	if err != nil {
+		return err
+	}
+	var users []parser.User
+	if *in != "" {
+		users, err = parser.LoadUsersFromCSV(*in)
+	} else if err = cfg.Require(config.NeedLDAP); err == nil {
+		var export *ldapExport
+		if export, err = exportUsers(cfg, true); err == nil {
+			users = export.Users
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	summaries, err := parser.CompareMatchers(users, *departments, matchers, cfg.Matching.Threshold, *out)
+	if err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		log.Printf("[INFO] %-12s %d of %d user(s) matched at threshold %.2f", s.Name, s.Matched, s.Total, cfg.Matching.Threshold)
+	}
+	log.Printf("[OK] Comparison written to %s", *out)
+	return nil
+}
+
+func cmdApproveDepartments(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("approve-departments")
+	report := fs.String("report", cfg.Paths.Output("dept-validation-errors-report.csv"), "Validation error report (CSV or XLSX) with an \"approve\" column")
+	departments := fs.String("departments", cfg.Paths.DepartmentYAML, "Department list YAML the aliases are added to")
+	fs.Parse(args)
+
+	aliases, err := parser.LoadApprovedAliases(*report)
+	if err != nil {
+		return err
+	}
+	if len(aliases) == 0 {
+		log.Printf("[INFO] No approved rows in %s", *report)
+		return nil
+	}
+	result, err := parser.ApproveDepartmentAliases(*departments, aliases)
+	if err != nil {
+		return err
+	}
+	for _, a := range result.Added {
+		log.Printf("[OK] '%s' added as alias of '%s'", a.Raw, a.Department)
+	}
+	for _, a := range result.Known {
+		log.Printf("[SKIP] '%s' is already an alias of '%s'", a.Raw, a.Department)
+	}
+	for _, r := range result.Rejected {
+		log.Printf("[WARN] '%s' not added to '%s': %s", r.Raw, r.Department, r.Reason)
+	}
+	log.Printf("[INFO] %d alias(es) added, %d already known, %d rejected", len(result.Added), len(result.Known), len(result.Rejected))
+	return nil
+}
+
+func cmdSyncTeams(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("sync-teams")
+	departments := fs.String("departments", cfg.Paths.DepartmentYAML, "Department list YAML; TeamIDs are written back to it")
+	dryRun := fs.Bool("dry-run", opts.DryRun, "Only write a plan report")
+	plan := fs.String("plan", cfg.Paths.Output("dry-run-plan.csv"), "Plan report written with --dry-run")
+	fs.Parse(args)
+
+	client, err := connectItop(cfg)
+	if err != nil {
+		return err
+	}
+	p := newPlan(*dryRun)
+	if err := syncTeams(cfg, client, *departments, p); err != nil {
+		return err
+	}
+	if p != nil {
+		return writePlan(p, *plan)
+	}
+	return nil
+}
+
+func cmdSyncUsers(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("sync-users")
+	in := fs.String("in", cfg.Paths.Output("users.csv"), "Users CSV with the valid department (validate-departments output, may be edited by hand)")
+	departments := fs.String("departments", cfg.Paths.DepartmentYAML, "Department list YAML with TeamIDs")
+	notSynced := fs.String("not-synced", cfg.Paths.Output("user-not-synchronized.csv"), "Report of users that could not be synced")
+	reconcile := fs.Bool("reconcile", false, "Remove members that are not in the CSV from managed Teams; only use with a complete CSV (SYNC_RECONCILE_MEMBERSHIP does not apply here)")
+	dryRun := fs.Bool("dry-run", opts.DryRun, "Only write a plan report")
+	plan := fs.String("plan", cfg.Paths.Output("dry-run-plan.csv"), "Plan report written with --dry-run")
+	fs.Parse(args)
+
+	client, err := connectItop(cfg)
+	if err != nil {
+		return err
+	}
+	stageCfg := *cfg
+	stageCfg.Sync.ReconcileMembership = *reconcile
+	p := newPlan(*dryRun)
+	if err := syncUsers(&stageCfg, client, *in, *departments, *notSynced, true, p); err != nil {
+		return err
+	}
+	if p != nil {
+		return writePlan(p, *plan)
+	}
+	return nil
+}
+
+func cmdReport(cfg *config.Config, opts runOptions, args []string) error {
+	fs := newFlagSet("report")
+	deptReport := fs.String("dept-report", cfg.Paths.Output("dept-validation-errors-report.csv"), "Department validation error report (empty to leave out)")
+	notSynced := fs.String("not-synced", cfg.Paths.Output("user-not-synchronized.csv"), "Not synchronized users report (empty to leave out)")
+	duplicates := fs.String("duplicates", cfg.Paths.Output("ldap-duplicate-users.csv"), "Duplicate LDAP users report (empty to leave out)")
+	fs.Parse(args)
+
+	sendErrorReport(cfg, errorReports{DeptReport: *deptReport, NotSynced: *notSynced, Duplicates: *duplicates})
+	return nil
+}
+
+func newPlan(dryRun bool) *synchronizer.Plan {
+	if !dryRun {
+		return nil
+	}
+	log.Println("[INFO] Dry-run mode: no changes will be made to iTop or the department YAML.")
+	return synchronizer.NewPlan()
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"ldap-itop/helper"
+	"ldap-itop/ldapclient"
+	"ldap-itop/parser"
+)
+
+// Config is the whole configuration of the synchronizer. It is read from a YAML
+// file, then every setting can be overridden by its env var (see .env.example).
+type Config struct {
+	LDAP     LDAPConfig     `yaml:"LDAP"`
+	ITop     ITopConfig     `yaml:"ITop"`
+	Matching MatchingConfig `yaml:"Matching"`
+	Sync     SyncConfig     `yaml:"Sync"`
+	Schedule ScheduleConfig `yaml:"Schedule"`
+	Paths    PathsConfig    `yaml:"Paths"`
+	// Exclude lists the CNs that are never synchronised
+	Exclude      []string          `yaml:"Exclude"`
+	Notification helper.MailConfig `yaml:"Notification"`
+
+	// sourceProblems are the problems found while loading the LDAP sources, only
+	// reported to commands that read LDAP
+	sourceProblems []string
+}
+
+type LDAPConfig struct {
+	Sources []ldapclient.Source `yaml:"Sources"`
+	// SourcesFile is a separate YAML list of sources that replaces Sources
+	SourcesFile string `yaml:"SourcesFile,omitempty"`
+	// AttributeMap overrides the LDAP attribute per field, e.g. "Email=userPrincipalName"
+	AttributeMap string `yaml:"AttributeMap,omitempty"`
+}
+
+type ITopConfig struct {
+	URL      string `yaml:"URL"`
+	User     string `yaml:"User"`
+	Password string `yaml:"Password,omitempty"`
+	Token    string `yaml:"Token,omitempty"`
+	AuthMode string `yaml:"AuthMode"`
+	Version  string `yaml:"Version"`
+	OrgID    string `yaml:"OrgID"`
+
+	RateLimit      float64           `yaml:"RateLimit"`
+	Workers        int               `yaml:"Workers"`
+	MaxRetries     int               `yaml:"MaxRetries"`
+	RetryBaseDelay time.Duration     `yaml:"RetryBaseDelay"`
+	Timeout        time.Duration     `yaml:"Timeout"`
+	TLS            helper.TLSOptions `yaml:"TLS,omitempty"`
+
+	ProvisionPersons      bool   `yaml:"ProvisionPersons"`
+	ProvisionUserAccounts bool   `yaml:"ProvisionUserAccounts"`
+	DefaultProfile        string `yaml:"DefaultProfile"`
+	SyncPersonAttributes  bool   `yaml:"SyncPersonAttributes"`
+	PersonFieldMap        string `yaml:"PersonFieldMap"`
+	SyncManagers          bool   `yaml:"SyncManagers"`
+	// MirrorHierarchy puts each Team in the Organization of its department, see
+	// the Organization and Parent fields of the department list
+	MirrorHierarchy bool `yaml:"MirrorHierarchy"`
+}
+
+type MatchingConfig struct {
+	// Threshold is the minimum similarity (0-1) for a department to be accepted
+	Threshold float64 `yaml:"Threshold"`
+	// Strategy is jarowinkler (default), exact, tokenset, levenshtein or weighted
+	Strategy string `yaml:"Strategy"`
+	// Weights of the strategies combined by "weighted", e.g. {tokenset: 0.6, jarowinkler: 0.4}
+	Weights map[string]float64 `yaml:"Weights,omitempty"`
+	// OUMode is how the OUs of the department list are used: fallback (default) or override
+	OUMode string `yaml:"OUMode"`
+}
+
+// Matcher builds the department matcher of the configured strategy
+func (m MatchingConfig) Matcher() (parser.Matcher, error) {
+	return parser.NewMatcher(m.options(m.Strategy))
+}
+
+// Matchers builds every strategy that can run with this configuration, plus the
+// configured combination, for the comparison report
+func (m MatchingConfig) Matchers() ([]parser.Matcher, error) {
+	var matchers []parser.Matcher
+	for _, name := range parser.MatchStrategies {
+		matcher, err := parser.NewMatcher(m.options(name))
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	if strings.EqualFold(m.Strategy, parser.MatchWeighted) {
+		matcher, err := m.Matcher()
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+func (m MatchingConfig) options(strategy string) parser.MatcherOptions {
+	return parser.MatcherOptions{Strategy: strategy, Weights: m.Weights}
+}
+
+type SyncConfig struct {
+	IncludeInactive     bool `yaml:"IncludeInactive"`
+	ReconcileMembership bool `yaml:"ReconcileMembership"`
+	// MaxRemovals skips all removals of a run when more would happen; -1 means no limit
+	MaxRemovals int `yaml:"MaxRemovals"`
+
+	Incremental          bool          `yaml:"Incremental"`
+	IncrementalAttribute string        `yaml:"IncrementalAttribute"`
+	StateFile            string        `yaml:"StateFile"`
+	FullEvery            time.Duration `yaml:"FullEvery"`
+}
+
+type ScheduleConfig struct {
+	// Cron takes precedence over Interval
+	Cron       string        `yaml:"Cron,omitempty"`
+	Interval   time.Duration `yaml:"Interval"`
+	RunOnStart bool          `yaml:"RunOnStart"`
+	// StatusAddr serves /healthz, /status and /metrics when set
+	StatusAddr string `yaml:"StatusAddr,omitempty"`
+}
+
+type PathsConfig struct {
+	DepartmentYAML string `yaml:"DepartmentYAML"`
+	OutputDir      string `yaml:"OutputDir"`
+}
+
+// Output returns the path of a report file in the output directory
+func (p PathsConfig) Output(name string) string {
+	return filepath.Join(p.OutputDir, name)
+}
+
+// Default returns the settings used when neither the file nor the env sets them
+func Default() *Config {
+	return &Config{
+		ITop: ITopConfig{
+			AuthMode:       "form",
+			Version:        "1.3",
+			Workers:        1,
+			MaxRetries:     3,
+			RetryBaseDelay: time.Second,
+			Timeout:        10 * time.Second,
+			DefaultProfile: "Portal user",
+		},
+		Matching: MatchingConfig{Threshold: 1.00, Strategy: parser.MatchJaroWinkler, OUMode: parser.OUFallback},
+		Sync: SyncConfig{
+			MaxRemovals:          20,
+			IncrementalAttribute: ldapclient.ChangeAttrUSN,
+			StateFile:            "data/sync-state.json",
+			FullEvery:            24 * time.Hour,
+		},
+		Schedule: ScheduleConfig{Interval: 24 * time.Hour, RunOnStart: true},
+		Paths: PathsConfig{
+			DepartmentYAML: "data/valid-department-list.yaml",
+			OutputDir:      "output",
+		},
+	}
+}
+
+// Load reads the config file (none when path is empty), applies the env overrides
+// and validates the settings of the needs. When anything is wrong the config is
+// still returned together with a *ValidationError listing every problem.
+func Load(path string, needs Needs) (*Config, error) {
+	cfg := Default()
+	var problems []string
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+	problems = append(problems, applyEnv(cfg)...)
+	cfg.sourceProblems = cfg.resolveSources()
+	problems = append(problems, cfg.Validate(needs)...)
+	if len(problems) > 0 {
+		return cfg, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// Require validates the settings of needs that were not checked by Load, for
+// commands whose needs depend on their flags
+func (c *Config) Require(needs Needs) error {
+	if problems := c.Validate(needs); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d configuration problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// resolveSources loads SourcesFile or applies the LDAP_* vars, then fills in
+// names and passwords from env
+func (c *Config) resolveSources() []string {
+	if c.LDAP.SourcesFile != "" {
+		sources, err := ldapclient.LoadSources(c.LDAP.SourcesFile)
+		if err != nil {
+			return []string{fmt.Sprintf("LDAP.SourcesFile: %v", err)}
+		}
+		c.LDAP.Sources = sources
+	}
+	problems := applyLDAPEnv(c)
+	ldapclient.ResolveSources(c.LDAP.Sources)
+	return problems
+}
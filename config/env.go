@@ -0,0 +1,236 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ldap-itop/helper"
+	"ldap-itop/ldapclient"
+)
+
+// envReader applies env overrides and collects the values it could not parse
+type envReader struct {
+	problems []string
+}
+
+func (r *envReader) invalid(name, value, expected string) {
+	r.problems = append(r.problems, fmt.Sprintf("%s: invalid value %q, expected %s", name, value, expected))
+}
+
+func (r *envReader) str(name string, dst *string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+func (r *envReader) boolean(name string, dst *bool) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.invalid(name, v, "true or false")
+		return
+	}
+	*dst = b
+}
+
+func (r *envReader) integer(name string, dst *int) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		r.invalid(name, v, "an integer")
+		return
+	}
+	*dst = n
+}
+
+func (r *envReader) float(name string, dst *float64) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.invalid(name, v, "a number")
+		return
+	}
+	*dst = f
+}
+
+func (r *envReader) duration(name string, dst *time.Duration) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		r.invalid(name, v, "a duration like 30s or 24h")
+		return
+	}
+	*dst = d
+}
+
+func (r *envReader) list(name, sep string, dst *[]string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = splitList(v, sep)
+	}
+}
+
+// weights reads "name=weight" pairs separated by ","
+func (r *envReader) weights(name string, dst *map[string]float64) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	weights := make(map[string]float64)
+	for _, pair := range splitList(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			r.invalid(name, v, "name=weight pairs like tokenset=0.6,jarowinkler=0.4")
+			return
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			r.invalid(name, v, "name=weight pairs like tokenset=0.6,jarowinkler=0.4")
+			return
+		}
+		weights[strings.TrimSpace(parts[0])] = w
+	}
+	*dst = weights
+}
+
+func (r *envReader) tls(prefix string, dst *helper.TLSOptions) {
+	r.boolean(prefix+"_TLS_INSECURE_SKIP_VERIFY", &dst.InsecureSkipVerify)
+	r.str(prefix+"_TLS_CA_FILE", &dst.CAFile)
+	r.str(prefix+"_TLS_CERT_FILE", &dst.CertFile)
+	r.str(prefix+"_TLS_KEY_FILE", &dst.KeyFile)
+	r.str(prefix+"_TLS_SERVER_NAME", &dst.ServerName)
+}
+
+// applyEnv overrides the settings that have an env var. The single-source LDAP_*
+// vars are applied by applyLDAPEnv once the sources are known.
+func applyEnv(c *Config) []string {
+	r := &envReader{}
+	r.str("LDAP_SOURCES_FILE", &c.LDAP.SourcesFile)
+	r.str("LDAP_ATTRIBUTE_MAP", &c.LDAP.AttributeMap)
+
+	r.str("ITOP_API_URL", &c.ITop.URL)
+	r.str("ITOP_API_USER", &c.ITop.User)
+	r.str("ITOP_API_PWD", &c.ITop.Password)
+	r.str("ITOP_API_TOKEN", &c.ITop.Token)
+	r.str("ITOP_AUTH_MODE", &c.ITop.AuthMode)
+	c.ITop.AuthMode = strings.ToLower(c.ITop.AuthMode)
+	r.str("ITOP_VERSION", &c.ITop.Version)
+	r.str("ITOP_ORG_ID", &c.ITop.OrgID)
+	r.float("ITOP_RATE_LIMIT", &c.ITop.RateLimit)
+	r.integer("ITOP_WORKERS", &c.ITop.Workers)
+	r.integer("ITOP_MAX_RETRIES", &c.ITop.MaxRetries)
+	r.duration("ITOP_RETRY_BASE_DELAY", &c.ITop.RetryBaseDelay)
+	r.duration("ITOP_TIMEOUT", &c.ITop.Timeout)
+	r.tls("ITOP", &c.ITop.TLS)
+	r.boolean("ITOP_PROVISION_PERSONS", &c.ITop.ProvisionPersons)
+	r.boolean("ITOP_PROVISION_USER_ACCOUNTS", &c.ITop.ProvisionUserAccounts)
+	r.str("ITOP_DEFAULT_PROFILE", &c.ITop.DefaultProfile)
+	r.boolean("ITOP_SYNC_PERSON_ATTRIBUTES", &c.ITop.SyncPersonAttributes)
+	r.str("ITOP_PERSON_FIELD_MAP", &c.ITop.PersonFieldMap)
+	r.boolean("ITOP_SYNC_MANAGERS", &c.ITop.SyncManagers)
+	r.boolean("ITOP_MIRROR_HIERARCHY", &c.ITop.MirrorHierarchy)
+
+	r.float("MATCH_THRESHOLD", &c.Matching.Threshold)
+	r.str("MATCH_STRATEGY", &c.Matching.Strategy)
+	r.weights("MATCH_WEIGHTS", &c.Matching.Weights)
+	r.str("MATCH_OU_MODE", &c.Matching.OUMode)
+
+	r.boolean("SYNC_INCLUDE_INACTIVE", &c.Sync.IncludeInactive)
+	r.boolean("SYNC_RECONCILE_MEMBERSHIP", &c.Sync.ReconcileMembership)
+	r.integer("SYNC_MAX_REMOVALS", &c.Sync.MaxRemovals)
+	r.boolean("SYNC_INCREMENTAL", &c.Sync.Incremental)
+	r.str("SYNC_INCREMENTAL_ATTRIBUTE", &c.Sync.IncrementalAttribute)
+	r.str("SYNC_STATE_FILE", &c.Sync.StateFile)
+	r.duration("SYNC_FULL_EVERY", &c.Sync.FullEvery)
+
+	r.str("SYNC_CRON", &c.Schedule.Cron)
+	r.duration("SYNC_INTERVAL", &c.Schedule.Interval)
+	r.boolean("SYNC_RUN_ON_START", &c.Schedule.RunOnStart)
+	r.str("STATUS_HTTP_ADDR", &c.Schedule.StatusAddr)
+
+	r.str("DEPARTMENT_YAML", &c.Paths.DepartmentYAML)
+	r.str("OUTPUT_DIR", &c.Paths.OutputDir)
+
+	r.list("EXCLUDE_LIST", ";", &c.Exclude)
+
+	r.str("EMAIL_FROM_ADDR", &c.Notification.FromAddr)
+	r.str("EMAIL_FROM_NAME", &c.Notification.FromName)
+	r.list("EMAIL_TO", ",", &c.Notification.To)
+	r.list("EMAIL_CC", ",", &c.Notification.Cc)
+	r.str("EMAIL_SUBJECT", &c.Notification.Subject)
+	r.str("EMAIL_SMTP_HOST", &c.Notification.SMTPHost)
+	r.str("EMAIL_SMTP_PORT", &c.Notification.SMTPPort)
+	// Historical name: "true" sends without TLS at all
+	r.boolean("EMAIL_SKIP_TLS_VERIFY", &c.Notification.Plain)
+	r.tls("EMAIL", &c.Notification.TLS)
+	return r.problems
+}
+
+// ldapEnvVars are the vars describing a single LDAP source
+var ldapEnvVars = []string{
+	"LDAP_URL", "LDAP_BIND_USER", "LDAP_BIND_PASSWORD", "LDAP_BASE_DN", "LDAP_SEARCH_FILTER",
+	"LDAP_PAGE_SIZE", "LDAP_START_TLS", "LDAP_TLS_INSECURE_SKIP_VERIFY", "LDAP_TLS_CA_FILE",
+	"LDAP_TLS_CERT_FILE", "LDAP_TLS_KEY_FILE", "LDAP_TLS_SERVER_NAME",
+}
+
+// applyLDAPEnv applies the LDAP_* vars to the only source, creating it when the
+// config has none. They are ignored when the sources come from SourcesFile.
+func applyLDAPEnv(c *Config) []string {
+	set := false
+	for _, name := range ldapEnvVars {
+		if os.Getenv(name) != "" {
+			set = true
+		}
+	}
+	if !set || c.LDAP.SourcesFile != "" {
+		return nil
+	}
+	if len(c.LDAP.Sources) > 1 {
+		return []string{"LDAP_* env vars can only override a single LDAP source, but several are configured"}
+	}
+	if len(c.LDAP.Sources) == 0 {
+		c.LDAP.Sources = []ldapclient.Source{{Name: "default"}}
+	}
+	src := &c.LDAP.Sources[0]
+	r := &envReader{}
+	r.str("LDAP_URL", &src.URL)
+	r.str("LDAP_BIND_USER", &src.BindUser)
+	r.str("LDAP_BIND_PASSWORD", &src.BindPassword)
+	r.list("LDAP_BASE_DN", ";", &src.BaseDNs)
+	r.str("LDAP_SEARCH_FILTER", &src.Filter)
+	if v := os.Getenv("LDAP_PAGE_SIZE"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			r.invalid("LDAP_PAGE_SIZE", v, "a positive integer")
+		} else {
+			src.PageSize = uint32(n)
+		}
+	}
+	r.boolean("LDAP_START_TLS", &src.StartTLS)
+	r.tls("LDAP", &src.TLS)
+	return r.problems
+}
+
+func splitList(raw, sep string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, sep) {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
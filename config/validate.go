@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+
+	"ldap-itop/helper"
+	"ldap-itop/itopclient"
+	"ldap-itop/ldapclient"
+	"ldap-itop/parser"
+	"ldap-itop/scheduler"
+	"ldap-itop/synchronizer"
+)
+
+// Needs are the groups of settings a command uses. The matching, sync and path
+// settings are always checked, the others only when a command needs them, so
+// offline commands run without LDAP or iTop credentials.
+type Needs uint
+
+const (
+	NeedLDAP Needs = 1 << iota
+	NeedITop
+	NeedMail
+	NeedSchedule
+	// NeedAll checks everything, for "config validate"
+	NeedAll = NeedLDAP | NeedITop | NeedMail | NeedSchedule
+)
+
+// Validate returns every problem found in the settings of needs, so they can all
+// be fixed at once instead of one per restart
+func (c *Config) Validate(needs Needs) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if needs&NeedLDAP != 0 {
+		problems = append(problems, c.sourceProblems...)
+		for _, err := range ldapclient.ValidateSources(c.LDAP.Sources) {
+			add("LDAP: %v", err)
+		}
+		for _, src := range c.LDAP.Sources {
+			if _, err := src.TLS.Config(""); err != nil {
+				add("LDAP source %q TLS: %v", src.Name, err)
+			}
+		}
+		if _, err := parser.ParseAttributeMap(c.LDAP.AttributeMap); err != nil {
+			add("LDAP.AttributeMap (LDAP_ATTRIBUTE_MAP): %v", err)
+		}
+	}
+
+	if needs&NeedITop != 0 {
+		if c.ITop.URL == "" {
+			add("ITop.URL (ITOP_API_URL) is required")
+		}
+		if c.ITop.Version == "" {
+			add("ITop.Version (ITOP_VERSION) is required")
+		}
+		if c.ITop.OrgID == "" {
+			add("ITop.OrgID (ITOP_ORG_ID) is required")
+		}
+		client := itopclient.ITopClient{Username: c.ITop.User, Token: c.ITop.Token, AuthMode: c.ITop.AuthMode}
+		if err := client.ValidateAuth(); err != nil {
+			add("ITop auth: %v", err)
+		}
+		if c.ITop.RateLimit < 0 {
+			add("ITop.RateLimit (ITOP_RATE_LIMIT) must not be negative")
+		}
+		if c.ITop.Workers < 1 {
+			add("ITop.Workers (ITOP_WORKERS) must be at least 1")
+		}
+		if c.ITop.MaxRetries < 0 {
+			add("ITop.MaxRetries (ITOP_MAX_RETRIES) must not be negative")
+		}
+		if c.ITop.RetryBaseDelay <= 0 {
+			add("ITop.RetryBaseDelay (ITOP_RETRY_BASE_DELAY) must be positive")
+		}
+		if c.ITop.Timeout <= 0 {
+			add("ITop.Timeout (ITOP_TIMEOUT) must be positive")
+		}
+		if _, err := c.ITop.TLS.Config(""); err != nil {
+			add("ITop.TLS: %v", err)
+		}
+		if c.ITop.ProvisionUserAccounts && c.ITop.DefaultProfile == "" {
+			add("ITop.DefaultProfile (ITOP_DEFAULT_PROFILE) is required to provision user accounts")
+		}
+		if _, err := c.PersonFields(); err != nil {
+			add("ITop.PersonFieldMap (ITOP_PERSON_FIELD_MAP): %v", err)
+		}
+	}
+
+	if c.Matching.Threshold <= 0 || c.Matching.Threshold > 1 {
+		add("Matching.Threshold (MATCH_THRESHOLD) must be between 0 and 1, got %g", c.Matching.Threshold)
+	}
+	if _, err := c.Matching.Matcher(); err != nil {
+		add("Matching (MATCH_STRATEGY, MATCH_WEIGHTS): %v", err)
+	}
+	if !parser.ValidOUMode(c.Matching.OUMode) {
+		add("Matching.OUMode (MATCH_OU_MODE) must be %s or %s, got %q", parser.OUFallback, parser.OUOverride, c.Matching.OUMode)
+	}
+
+	if c.Sync.MaxRemovals < -1 {
+		add("Sync.MaxRemovals (SYNC_MAX_REMOVALS) must be -1 (no limit) or more")
+	}
+	if c.Sync.IncrementalAttribute != ldapclient.ChangeAttrUSN && c.Sync.IncrementalAttribute != ldapclient.ChangeAttrWhenChanged {
+		add("Sync.IncrementalAttribute (SYNC_INCREMENTAL_ATTRIBUTE) must be %s or %s, got %q",
+			ldapclient.ChangeAttrUSN, ldapclient.ChangeAttrWhenChanged, c.Sync.IncrementalAttribute)
+	}
+	if c.Sync.Incremental && c.Sync.StateFile == "" {
+		add("Sync.StateFile (SYNC_STATE_FILE) is required for incremental sync")
+	}
+	if c.Sync.FullEvery <= 0 {
+		add("Sync.FullEvery (SYNC_FULL_EVERY) must be positive")
+	}
+
+	if needs&NeedSchedule != 0 {
+		if c.Schedule.Cron != "" {
+			if _, err := scheduler.ParseCron(c.Schedule.Cron); err != nil {
+				add("Schedule.Cron (SYNC_CRON): %v", err)
+			}
+		} else if c.Schedule.Interval <= 0 {
+			add("Schedule.Interval (SYNC_INTERVAL) must be positive")
+		}
+	}
+
+	if c.Paths.DepartmentYAML == "" {
+		add("Paths.DepartmentYAML (DEPARTMENT_YAML) is required")
+	} else if deptList, err := parser.LoadDepartmentList(c.Paths.DepartmentYAML); err != nil {
+		add("Paths.DepartmentYAML: %v", err)
+	} else {
+		if _, err := parser.CompileRules(deptList); err != nil {
+			add("Paths.DepartmentYAML: %v", err)
+		}
+		if err := synchronizer.CheckHierarchy(deptList); needs&NeedITop != 0 && c.ITop.MirrorHierarchy && err != nil {
+			add("Paths.DepartmentYAML (ITOP_MIRROR_HIERARCHY): %v", err)
+		}
+	}
+	if c.Paths.OutputDir == "" {
+		add("Paths.OutputDir (OUTPUT_DIR) is required")
+	}
+
+	if needs&NeedMail != 0 {
+		problems = append(problems, validateMail(c.Notification)...)
+	}
+	return problems
+}
+
+func validateMail(m helper.MailConfig) []string {
+	// No recipients means no error email is sent
+	if len(m.To) == 0 {
+		return nil
+	}
+	var problems []string
+	if m.FromAddr == "" {
+		problems = append(problems, "Notification.FromAddr (EMAIL_FROM_ADDR) is required when recipients are set")
+	}
+	if m.SMTPHost == "" || m.SMTPPort == "" {
+		problems = append(problems, "Notification.SMTPHost and SMTPPort (EMAIL_SMTP_HOST, EMAIL_SMTP_PORT) are required when recipients are set")
+	}
+	if _, err := m.TLS.Config(""); err != nil {
+		problems = append(problems, fmt.Sprintf("Notification.TLS: %v", err))
+	}
+	return problems
+}
+
+// PersonFields returns the parsed Person field mapping, or the default one
+func (c *Config) PersonFields() ([]synchronizer.PersonFieldMapping, error) {
+	spec := c.ITop.PersonFieldMap
+	if spec == "" {
+		spec = synchronizer.DefaultPersonFieldMap
+	}
+	return synchronizer.ParsePersonFieldMap(spec)
+}
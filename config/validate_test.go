@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateNeeds(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "departments.yaml")
+	if err := os.WriteFile(yamlPath, []byte("- DepartmentName: DIGI\n  SubList:\n  - \"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A workstation without LDAP, iTop or mail settings
+	cfg := Default()
+	cfg.Paths.DepartmentYAML = yamlPath
+	cfg.Notification.To = []string{"ops@example.com"}
+
+	if problems := cfg.Validate(0); len(problems) != 0 {
+		t.Errorf("offline command: %v, want no problems", problems)
+	}
+	tests := []struct {
+		needs    Needs
+		expected string
+	}{
+		{NeedLDAP, "no LDAP source"},
+		{NeedITop, "ITop.URL"},
+		{NeedMail, "Notification.FromAddr"},
+	}
+	for _, tt := range tests {
+		problems := strings.Join(cfg.Validate(tt.needs), "\n")
+		if !strings.Contains(problems, tt.expected) {
+			t.Errorf("Validate(%d) = %q, want a problem about %s", tt.needs, problems, tt.expected)
+		}
+	}
+	if err := cfg.Require(NeedITop); err == nil {
+		t.Error("Require(NeedITop) succeeded without an iTop URL")
+	}
+
+	// Settings every command uses are always checked
+	cfg.Matching.Threshold = 2
+	if problems := cfg.Validate(0); len(problems) != 1 {
+		t.Errorf("Validate(0) = %v, want the threshold problem", problems)
+	}
+}
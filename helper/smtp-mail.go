@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/smtp"
-	"os"
 	"strings"
 )
 
@@ -43,18 +42,28 @@ func EncodeBase64(data []byte) string {
 	return out.String()
 }
 
+// MailConfig holds the SMTP server and recipients of the error email
+type MailConfig struct {
+	FromAddr string   `yaml:"FromAddr"`
+	FromName string   `yaml:"FromName"`
+	To       []string `yaml:"To"`
+	Cc       []string `yaml:"Cc,omitempty"`
+	Subject  string   `yaml:"Subject"`
+	SMTPHost string   `yaml:"SMTPHost"`
+	SMTPPort string   `yaml:"SMTPPort"`
+	// Plain sends over an unencrypted connection instead of implicit TLS
+	Plain bool       `yaml:"Plain,omitempty"`
+	TLS   TLSOptions `yaml:"TLS,omitempty"`
+}
+
 // SendErrorMail sends an email with optional attachments
-func SendErrorMail(subject, body string, attachments map[string][]byte) error {
-	from := os.Getenv("EMAIL_FROM_ADDR")
-	fromName := os.Getenv("EMAIL_FROM_NAME")
-	toList := strings.Split(os.Getenv("EMAIL_TO"), ",")
-	ccList := []string{}
-	if cc := os.Getenv("EMAIL_CC"); cc != "" {
-		ccList = strings.Split(cc, ",")
-	}
-	smtpHost := os.Getenv("EMAIL_SMTP_HOST")
-	smtpPort := os.Getenv("EMAIL_SMTP_PORT")
-	skipTLS := strings.ToLower(os.Getenv("EMAIL_SKIP_TLS_VERIFY")) == "true"
+func SendErrorMail(cfg MailConfig, subject, body string, attachments map[string][]byte) error {
+	from := cfg.FromAddr
+	fromName := cfg.FromName
+	toList := cfg.To
+	ccList := cfg.Cc
+	smtpHost := cfg.SMTPHost
+	smtpPort := cfg.SMTPPort
 
 	// prepare headers
 	boundary := "BOUNDARY-1234567890"
@@ -88,12 +97,12 @@ func SendErrorMail(subject, body string, attachments map[string][]byte) error {
 	msg.WriteString("--" + boundary + "--\r\n")
 
 	addr := smtpHost + ":" + smtpPort
-	allRecipients := append(toList, ccList...)
+	allRecipients := append(append([]string(nil), toList...), ccList...)
 
-	if skipTLS {
+	if cfg.Plain {
 		return sendPlain(addr, from, allRecipients, strings.NewReader(msg.String()))
 	}
-	return sendTLS(addr, smtpHost, from, allRecipients, strings.NewReader(msg.String()))
+	return sendTLS(addr, smtpHost, from, allRecipients, strings.NewReader(msg.String()), cfg.TLS)
 }
 
 func sendPlain(addr, from string, to []string, r io.Reader) error {
@@ -125,8 +134,11 @@ func sendPlain(addr, from string, to []string, r io.Reader) error {
 	return c.Quit()
 }
 
-func sendTLS(addr, host, from string, to []string, r io.Reader) error {
-	tlsConfig := &tls.Config{InsecureSkipVerify: true, ServerName: host}
+func sendTLS(addr, host, from string, to []string, r io.Reader, opts TLSOptions) error {
+	tlsConfig, err := opts.Config(host)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP TLS settings: %w", err)
+	}
 	conn, err := tls.Dial("tcp", addr, tlsConfig)
 	if err != nil {
 		return err
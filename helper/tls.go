@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions are the TLS settings of one endpoint (iTop, LDAP or SMTP). The zero
+// value verifies the server against the system CA pool.
+type TLSOptions struct {
+	// InsecureSkipVerify disables certificate verification; only for testing
+	InsecureSkipVerify bool `yaml:"InsecureSkipVerify,omitempty"`
+	// CAFile is a PEM bundle of CAs trusted in addition to the system pool
+	CAFile string `yaml:"CAFile,omitempty"`
+	// CertFile and KeyFile are a PEM client certificate and key, for mutual TLS
+	CertFile string `yaml:"CertFile,omitempty"`
+	KeyFile  string `yaml:"KeyFile,omitempty"`
+	// ServerName overrides the name checked against the server certificate, e.g.
+	// when connecting by IP address
+	ServerName string `yaml:"ServerName,omitempty"`
+}
+
+// Config builds the tls.Config; serverName is used unless ServerName overrides it
+func (o TLSOptions) Config(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.ServerName != "" {
+		cfg.ServerName = o.ServerName
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in CA file %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate needs both a cert file and a key file")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
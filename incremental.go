@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"ldap-itop/config"
+	"ldap-itop/ldapclient"
+)
+
+// needsFullSync is true when any source has no usable marker yet, the change
+// attribute was switched, or the last full sync is older than FullEvery
+func needsFullSync(cfg config.SyncConfig, state ldapclient.SyncState, sources []ldapclient.Source, now time.Time) bool {
+	for _, src := range sources {
+		st, ok := state[src.Name]
+		if !ok || st.HighestChange == "" || st.ChangeAttr != cfg.IncrementalAttribute {
+			return true
+		}
+		if now.Sub(st.LastFullSync) >= cfg.FullEvery {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package itopclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// API is the set of iTop operations used by the synchronizer. ITopClient talks to a
+// real iTop over REST; FakeClient keeps everything in memory.
+type API interface {
+	// GetTeams returns every Team in iTop
+	GetTeams() ([]Team, error)
+	// GetUserContactIDs returns the Person id linked to every User, by lowercased login.
+	// Users without a contact are left out.
+	GetUserContactIDs() (map[string]string, error)
+	// GetTeamsMembers returns the persons_list of the given Teams, by TeamID. Teams
+	// that do not exist are missing from the result.
+	GetTeamsMembers(teamIDs []string) (map[string][]TeamMember, error)
+	// UpdateTeamMembers replaces the persons_list of a Team and returns the list stored by iTop
+	UpdateTeamMembers(teamID string, members []TeamMember, comment string) ([]TeamMember, error)
+	// CreateTeam creates an active Team in the given organization and returns its id
+	CreateTeam(name, orgID, comment string) (string, error)
+	// MoveTeam sets the organization of a Team
+	MoveTeam(teamID, orgID, comment string) error
+	// GetOrganizations returns every Organization in iTop
+	GetOrganizations() ([]Organization, error)
+	// CreateOrganization creates an active Organization under parentID (none when
+	// empty) and returns its id
+	CreateOrganization(name, parentID, comment string) (string, error)
+	// MoveOrganization sets the parent of an Organization
+	MoveOrganization(orgID, parentID, comment string) error
+	// FindPersonByEmail returns the id of the Person with the given email, or an empty
+	// string when there is none. Several matches are reported as an error.
+	FindPersonByEmail(email string) (string, error)
+	// CreatePerson creates a Person and returns its id
+	CreatePerson(p Person, comment string) (string, error)
+	// CreateUserLDAP creates an LDAP-authenticated User for a Person with one profile
+	// and returns its id
+	CreateUserLDAP(login, contactID, profile, comment string) (string, error)
+	// GetPerson returns the requested fields of a Person
+	GetPerson(id string, fields []string) (map[string]string, error)
+	// UpdatePerson sets the given fields of a Person
+	UpdatePerson(id string, fields map[string]string, comment string) error
+}
+
+type Team struct {
+	ID    string
+	Name  string
+	OrgID string
+}
+
+type Organization struct {
+	ID       string
+	Name     string
+	ParentID string
+}
+
+// TeamMember is one lnkPersonToTeam entry of a Team's persons_list
+type TeamMember struct {
+	PersonID   string
+	PersonName string
+	RoleID     string
+}
+
+// Person holds the fields set when provisioning a Person
+type Person struct {
+	Name      string // last name
+	FirstName string
+	Email     string
+	Phone     string
+	OrgID     string
+}
+
+func (c *ITopClient) GetTeams() ([]Team, error) {
+	objs, err := c.Get("Team", "SELECT Team", []string{"id", "name", "org_id"})
+	if err != nil {
+		return nil, err
+	}
+	teams := make([]Team, 0, len(objs))
+	for _, obj := range objs {
+		teams = append(teams, Team{ID: obj.String("id"), Name: obj.String("name"), OrgID: obj.String("org_id")})
+	}
+	return teams, nil
+}
+
+func (c *ITopClient) GetUserContactIDs() (map[string]string, error) {
+	objs, err := c.Get("User", "SELECT User", []string{"login", "contactid"})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string, len(objs))
+	for _, obj := range objs {
+		login := strings.ToLower(obj.String("login"))
+		if id := obj.String("contactid"); login != "" && id != "" && id != "0" {
+			ids[login] = id
+		}
+	}
+	return ids, nil
+}
+
+func (c *ITopClient) GetTeamsMembers(teamIDs []string) (map[string][]TeamMember, error) {
+	members := make(map[string][]TeamMember, len(teamIDs))
+	if len(teamIDs) == 0 {
+		return members, nil
+	}
+	for _, id := range teamIDs {
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid TeamID %q", id)
+		}
+	}
+	objs, err := c.Get("Team", fmt.Sprintf("SELECT Team WHERE id IN (%s)", strings.Join(teamIDs, ",")), []string{"id", "persons_list"})
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		members[obj.String("id")] = parsePersonsList(obj)
+	}
+	return members, nil
+}
+
+func (c *ITopClient) UpdateTeamMembers(teamID string, members []TeamMember, comment string) ([]TeamMember, error) {
+	personsList := make([]map[string]interface{}, 0, len(members))
+	for _, m := range members {
+		roleID := m.RoleID
+		if roleID == "" {
+			roleID = "0"
+		}
+		personsList = append(personsList, map[string]interface{}{
+			"person_id": m.PersonID,
+			"role_id":   roleID,
+		})
+	}
+	obj, err := c.Update("Team", teamID, map[string]interface{}{"persons_list": personsList}, comment, "persons_list")
+	if err != nil {
+		return nil, err
+	}
+	return parsePersonsList(obj), nil
+}
+
+func (c *ITopClient) CreateTeam(name, orgID, comment string) (string, error) {
+	obj, err := c.Create("Team", map[string]interface{}{
+		"name":   name,
+		"org_id": orgID,
+		"status": "active",
+	}, comment)
+	return obj.Key, err
+}
+
+func (c *ITopClient) MoveTeam(teamID, orgID, comment string) error {
+	_, err := c.Update("Team", teamID, map[string]interface{}{"org_id": orgID}, comment)
+	return err
+}
+
+func (c *ITopClient) GetOrganizations() ([]Organization, error) {
+	objs, err := c.Get("Organization", "SELECT Organization", []string{"id", "name", "parent_id"})
+	if err != nil {
+		return nil, err
+	}
+	orgs := make([]Organization, 0, len(objs))
+	for _, obj := range objs {
+		orgs = append(orgs, Organization{ID: obj.String("id"), Name: obj.String("name"), ParentID: obj.String("parent_id")})
+	}
+	return orgs, nil
+}
+
+func (c *ITopClient) CreateOrganization(name, parentID, comment string) (string, error) {
+	fields := map[string]interface{}{
+		"name":   name,
+		"status": "active",
+	}
+	if parentID != "" {
+		fields["parent_id"] = parentID
+	}
+	obj, err := c.Create("Organization", fields, comment)
+	return obj.Key, err
+}
+
+func (c *ITopClient) MoveOrganization(orgID, parentID, comment string) error {
+	_, err := c.Update("Organization", orgID, map[string]interface{}{"parent_id": parentID}, comment)
+	return err
+}
+
+func (c *ITopClient) FindPersonByEmail(email string) (string, error) {
+	objs, err := c.Get("Person", fmt.Sprintf("SELECT Person WHERE email=\"%s\"", escapeOQL(email)), []string{"id"})
+	if err != nil {
+		return "", err
+	}
+	if len(objs) > 1 {
+		return "", fmt.Errorf("%d Persons share the email %s", len(objs), email)
+	}
+	if len(objs) == 0 {
+		return "", nil
+	}
+	return objs[0].Key, nil
+}
+
+func (c *ITopClient) CreatePerson(p Person, comment string) (string, error) {
+	obj, err := c.Create("Person", map[string]interface{}{
+		"name":       p.Name,
+		"first_name": p.FirstName,
+		"email":      p.Email,
+		"phone":      p.Phone,
+		"org_id":     p.OrgID,
+	}, comment)
+	return obj.Key, err
+}
+
+func (c *ITopClient) CreateUserLDAP(login, contactID, profile, comment string) (string, error) {
+	obj, err := c.Create("UserLDAP", map[string]interface{}{
+		"login":     login,
+		"contactid": contactID,
+		"status":    "enabled",
+		"profile_list": []map[string]interface{}{
+			{"profileid": map[string]interface{}{"name": profile}},
+		},
+	}, comment)
+	return obj.Key, err
+}
+
+func (c *ITopClient) GetPerson(id string, fields []string) (map[string]string, error) {
+	objs, err := c.Get("Person", id, fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("Person::%s not found in iTop", id)
+	}
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		values[f] = objs[0].String(f)
+	}
+	return values, nil
+}
+
+func (c *ITopClient) UpdatePerson(id string, fields map[string]string, comment string) error {
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = v
+	}
+	_, err := c.Update("Person", id, values, comment)
+	return err
+}
+
+func parsePersonsList(team Object) []TeamMember {
+	links := team.LinkSet("persons_list")
+	members := make([]TeamMember, 0, len(links))
+	for _, pm := range links {
+		members = append(members, TeamMember{
+			PersonID:   fieldString(pm, "person_id"),
+			PersonName: fieldString(pm, "person_id_friendlyname"),
+			RoleID:     fieldString(pm, "role_id"),
+		})
+	}
+	return members
+}
+
+// fieldString reads an iTop field that may come back as a string or a JSON number
+func fieldString(fields map[string]interface{}, name string) string {
+	switch v := fields[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
+
+func escapeOQL(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`)
+}
@@ -3,79 +3,200 @@ package itopclient
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Authentication modes of the REST API
+const (
+	AuthForm  = "form"  // auth_user/auth_pwd form fields
+	AuthBasic = "basic" // HTTP basic auth with Username/Password
+	AuthToken = "token" // auth_token form field, for iTop personal or application tokens
+)
+
 type ITopClient struct {
 	BaseURL  string
 	Username string
 	Password string
 	Version  string
+	// AuthMode is AuthForm (the default when empty), AuthBasic or AuthToken
+	AuthMode string
+	// Token is sent as auth_token in AuthToken mode
+	Token string
+	// RateLimit caps the requests per second sent by Post, shared by all goroutines
+	// using this client; 0 means unlimited
+	RateLimit float64
+	// MaxRetries is how many times Post repeats a request after a timeout, a
+	// transport error or a 5xx answer; 0 disables retries
+	MaxRetries int
+	// RetryBaseDelay is the wait before the first retry, doubled on every further
+	// attempt with random jitter; defaults to 1s
+	RetryBaseDelay time.Duration
+	// Timeout bounds one HTTP request; defaults to 10s
+	Timeout time.Duration
+	// TLS is used for https URLs; nil verifies the server against the system CAs
+	TLS *tls.Config
+
+	limiter    rateLimiter
+	clientOnce sync.Once
+	httpClient *http.Client
 }
 
+const (
+	defaultRetryBaseDelay = time.Second
+	maxRetryDelay         = 30 * time.Second
+	defaultTimeout        = 10 * time.Second
+)
+
+// Post sends one REST operation and returns the raw response body. Failures are
+// returned as *TransportError or *HTTPStatusError; retryable ones are repeated with
+// exponential backoff first.
 func (c *ITopClient) Post(operation string, params map[string]interface{}) ([]byte, error) {
 	params["operation"] = operation
-	jsonData, _ := json.Marshal(params)
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
 
 	form := url.Values{}
 	form.Set("version", c.Version)
-	form.Set("auth_user", c.Username)
-	form.Set("auth_pwd", c.Password)
+	switch c.AuthMode {
+	case AuthToken:
+		form.Set("auth_token", c.Token)
+	case AuthBasic:
+		// Sent as a header by postOnce
+	default:
+		form.Set("auth_user", c.Username)
+		form.Set("auth_pwd", c.Password)
+	}
 	form.Set("json_data", string(jsonData))
+	payload := form.Encode()
 
-	req, err := http.NewRequest("POST", c.BaseURL, strings.NewReader(form.Encode()))
+	for attempt := 0; ; attempt++ {
+		body, err := c.postOnce(operation, payload)
+		if err == nil || attempt >= c.MaxRetries || !retryable(operation, err) {
+			return body, err
+		}
+		delay := c.backoff(attempt)
+		log.Printf("[WARN] %v (attempt %d/%d), retrying in %s", err, attempt+1, c.MaxRetries+1, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
+
+func (c *ITopClient) postOnce(operation, payload string) ([]byte, error) {
+	c.limiter.wait(c.RateLimit)
+
+	req, err := http.NewRequest("POST", c.BaseURL, strings.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	// Add a timeout to prevent hanging requests
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   10 * time.Second,
+	if c.AuthMode == AuthBasic {
+		req.SetBasicAuth(c.Username, c.Password)
 	}
-	resp, err := client.Do(req)
+
+	resp, err := c.client().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &TransportError{Operation: operation, Err: err}
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		log.Printf("iTop API response status: %d", resp.StatusCode)
-		log.Printf("iTop API response body: %s", string(body))
-		return nil, err
+	if err != nil {
+		return nil, &TransportError{Operation: operation, Err: err}
 	}
-	return body, err
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
 }
 
-// Authenticate checks iTop credentials by making a simple API call
-func (c *ITopClient) Authenticate() error {
-	params := map[string]interface{}{
-		"class":         "Organization",
-		"key":           "SELECT Organization WHERE id=3",
-		"output_fields": "id",
+// client returns the HTTP client shared by all requests, so connections are reused
+func (c *ITopClient) client() *http.Client {
+	c.clientOnce.Do(func() {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = c.TLS
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		// Add a timeout to prevent hanging requests
+		c.httpClient = &http.Client{
+			Transport: tr,
+			Timeout:   timeout,
+		}
+	})
+	return c.httpClient
+}
+
+// backoff returns the wait before retry number attempt+1: base * 2^attempt, capped,
+// with "equal jitter" so concurrent workers do not retry in lockstep
+func (c *ITopClient) backoff(attempt int) time.Duration {
+	base := c.RetryBaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
 	}
-	resp, err := c.Post("core/get", params)
-	if err != nil {
-		return err
+	d := maxRetryDelay
+	if attempt < 16 && base<<uint(attempt) < maxRetryDelay {
+		d = base << uint(attempt)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ValidateAuth checks that the settings needed by AuthMode are present
+func (c *ITopClient) ValidateAuth() error {
+	switch c.AuthMode {
+	case "", AuthForm, AuthBasic:
+		if c.Username == "" {
+			return fmt.Errorf("iTop auth mode %q needs a user name", c.authMode())
+		}
+	case AuthToken:
+		if c.Token == "" {
+			return fmt.Errorf("iTop auth mode %q needs a token", AuthToken)
+		}
+	default:
+		return fmt.Errorf("unknown iTop auth mode %q (expected %s, %s or %s)", c.AuthMode, AuthForm, AuthBasic, AuthToken)
 	}
-	// Optionally, check for iTop error in response JSON
-	var result map[string]interface{}
-	if err := json.Unmarshal(resp, &result); err != nil {
+	return nil
+}
+
+func (c *ITopClient) authMode() string {
+	if c.AuthMode == "" {
+		return AuthForm
+	}
+	return c.AuthMode
+}
+
+// Authenticate checks the iTop credentials. With a user and password it asks
+// core/check_credentials; a token cannot be checked that way, so list_operations,
+// which any REST user may call, is used instead.
+func (c *ITopClient) Authenticate() error {
+	if err := c.ValidateAuth(); err != nil {
 		return err
 	}
-	if _, ok := result["objects"]; !ok {
-		return &AuthError{Message: "No objects returned, possible authentication failure"}
+	var err error
+	if c.AuthMode == AuthToken {
+		_, err = c.call("list_operations", map[string]interface{}{})
+	} else {
+		var ok bool
+		ok, err = c.CheckCredentials(c.Username, c.Password)
+		if err == nil && !ok {
+			return &AuthError{Message: fmt.Sprintf("iTop rejected the credentials of %s", c.Username)}
+		}
 	}
-	return nil
+	var apiErr *APIError
+	// Code 1 is UNAUTHORIZED: wrong login, password or token, or no REST profile
+	if errors.As(err, &apiErr) && apiErr.Code == 1 {
+		return &AuthError{Message: apiErr.Message}
+	}
+	return err
 }
 
 type AuthError struct {
@@ -0,0 +1,121 @@
+package itopclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TransportError is returned when the request could not be sent or no response
+// was received (DNS, connection refused, TLS, timeout)
+type TransportError struct {
+	Operation string
+	Err       error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("iTop %s: transport error: %v", e.Operation, e.Err)
+}
+
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// Timeout reports whether the request timed out
+func (e *TransportError) Timeout() bool {
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// notSent reports whether the request failed before reaching iTop, so it is safe
+// to retry even for operations that are not idempotent
+func (e *TransportError) notSent() bool {
+	var opErr *net.OpError
+	return errors.As(e.Err, &opErr) && opErr.Op == "dial"
+}
+
+// HTTPStatusError is returned when iTop answers with a status other than 200
+type HTTPStatusError struct {
+	Operation  string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("iTop %s: HTTP %d: %s", e.Operation, e.StatusCode, truncate(e.Body, 200))
+}
+
+// APIError is returned when iTop answers with a non-zero code
+type APIError struct {
+	Operation string
+	Code      int
+	Message   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("iTop API error on %s: %s (code %d)", e.Operation, e.Message, e.Code)
+}
+
+// DecodeError is returned when the iTop response is not the expected JSON
+type DecodeError struct {
+	Operation string
+	Err       error
+	Body      string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("iTop %s: invalid response: %v: %s", e.Operation, e.Err, truncate(e.Body, 200))
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// idempotentOperations may be sent again after iTop possibly processed them:
+// reads, and core/update, which this client only uses to set fields and whole
+// link lists to absolute values
+var idempotentOperations = map[string]bool{
+	"core/get":               true,
+	"core/update":            true,
+	"core/check_credentials": true,
+	"list_operations":        true,
+}
+
+// retryable decides whether a failed call may be sent again. Operations in
+// idempotentOperations are retried after timeouts and server errors. Any other
+// operation (core/create, core/delete, core/apply_stimulus, ...) may already have
+// been processed then, so it is only repeated when the request surely never
+// reached iTop or iTop asked to come back later (429, 503).
+func retryable(operation string, err error) bool {
+	var te *TransportError
+	var he *HTTPStatusError
+	idempotent := idempotentOperations[operation]
+	switch {
+	case errors.As(err, &te):
+		// A rejected certificate will not be accepted on the next attempt either
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return false
+		}
+		return idempotent || te.notSent()
+	case errors.As(err, &he):
+		if he.StatusCode == 429 || he.StatusCode == 503 {
+			return true
+		}
+		return idempotent && he.StatusCode >= 500
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// IsUnavailable reports whether err means iTop could not be reached or failed on
+// the server side (after retries), as opposed to rejecting one particular request.
+// Callers looping over many objects stop on such errors instead of failing each one.
+func IsUnavailable(err error) bool {
+	var te *TransportError
+	var he *HTTPStatusError
+	return errors.As(err, &te) || (errors.As(err, &he) && (he.StatusCode >= 500 || he.StatusCode == 429))
+}
@@ -0,0 +1,313 @@
+package itopclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeClient is an in-memory implementation of API. It is meant for tests and for
+// trying out the synchronizer without a live iTop.
+type FakeClient struct {
+	mu      sync.Mutex
+	nextID  int
+	teams   map[string]*fakeTeam
+	orgs    map[string]Organization
+	users   map[string]string // login -> contactid
+	persons map[string]Person
+	// personFields holds Person attributes beyond the ones in Person, by id
+	personFields map[string]map[string]string
+	Creates      int
+	Updates      int
+}
+
+type fakeTeam struct {
+	Team
+	Members []TeamMember
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		nextID:  1000,
+		teams:   make(map[string]*fakeTeam),
+		orgs:    make(map[string]Organization),
+		users:   make(map[string]string),
+		persons: make(map[string]Person),
+
+		personFields: make(map[string]map[string]string),
+	}
+}
+
+// AddTeam seeds a Team with the given id and members
+func (f *FakeClient) AddTeam(id, name string, members ...TeamMember) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.teams[id] = &fakeTeam{Team: Team{ID: id, Name: name}, Members: append([]TeamMember(nil), members...)}
+}
+
+// AddOrganization seeds an Organization with the given id
+func (f *FakeClient) AddOrganization(id, name, parentID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orgs[id] = Organization{ID: id, Name: name, ParentID: parentID}
+}
+
+// AddUser seeds a User with the given login linked to the Person contactID
+func (f *FakeClient) AddUser(login, contactID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[login] = contactID
+}
+
+// AddPerson seeds a Person with the given id
+func (f *FakeClient) AddPerson(id string, p Person) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.persons[id] = p
+}
+
+// Members returns a copy of the current persons_list of a Team
+func (f *FakeClient) Members(teamID string) []TeamMember {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.teams[teamID]
+	if !ok {
+		return nil
+	}
+	return append([]TeamMember(nil), t.Members...)
+}
+
+func (f *FakeClient) GetTeams() ([]Team, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	teams := make([]Team, 0, len(f.teams))
+	for _, t := range f.teams {
+		teams = append(teams, t.Team)
+	}
+	return teams, nil
+}
+
+func (f *FakeClient) GetUserContactIDs() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make(map[string]string, len(f.users))
+	for login, id := range f.users {
+		ids[strings.ToLower(login)] = id
+	}
+	return ids, nil
+}
+
+func (f *FakeClient) GetTeamsMembers(teamIDs []string) (map[string][]TeamMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	members := make(map[string][]TeamMember, len(teamIDs))
+	for _, id := range teamIDs {
+		if t, ok := f.teams[id]; ok {
+			members[id] = append([]TeamMember(nil), t.Members...)
+		}
+	}
+	return members, nil
+}
+
+func (f *FakeClient) UpdateTeamMembers(teamID string, members []TeamMember, comment string) ([]TeamMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.teams[teamID]
+	if !ok {
+		return nil, &APIError{Operation: "core/update", Code: 100, Message: "Team::" + teamID + " not found"}
+	}
+	t.Members = make([]TeamMember, 0, len(members))
+	for _, m := range members {
+		if m.RoleID == "" {
+			m.RoleID = "0"
+		}
+		t.Members = append(t.Members, m)
+	}
+	f.Updates++
+	return append([]TeamMember(nil), t.Members...), nil
+}
+
+func (f *FakeClient) CreateTeam(name, orgID, comment string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.teams {
+		if strings.EqualFold(t.Name, name) {
+			return "", &APIError{Operation: "core/create", Code: 100, Message: "Team " + name + " already exists"}
+		}
+	}
+	f.nextID++
+	id := strconv.Itoa(f.nextID)
+	f.teams[id] = &fakeTeam{Team: Team{ID: id, Name: name, OrgID: orgID}}
+	f.Creates++
+	return id, nil
+}
+
+func (f *FakeClient) MoveTeam(teamID, orgID, comment string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.teams[teamID]
+	if !ok {
+		return &APIError{Operation: "core/update", Code: 100, Message: "Team::" + teamID + " not found"}
+	}
+	t.OrgID = orgID
+	f.Updates++
+	return nil
+}
+
+func (f *FakeClient) GetOrganizations() ([]Organization, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	orgs := make([]Organization, 0, len(f.orgs))
+	for _, o := range f.orgs {
+		orgs = append(orgs, o)
+	}
+	return orgs, nil
+}
+
+func (f *FakeClient) CreateOrganization(name, parentID, comment string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, o := range f.orgs {
+		if strings.EqualFold(o.Name, name) {
+			return "", &APIError{Operation: "core/create", Code: 100, Message: "Organization " + name + " already exists"}
+		}
+	}
+	if _, ok := f.orgs[parentID]; parentID != "" && !ok {
+		return "", &APIError{Operation: "core/create", Code: 100, Message: "Organization::" + parentID + " not found"}
+	}
+	f.nextID++
+	id := strconv.Itoa(f.nextID)
+	f.orgs[id] = Organization{ID: id, Name: name, ParentID: parentID}
+	f.Creates++
+	return id, nil
+}
+
+func (f *FakeClient) MoveOrganization(orgID, parentID, comment string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o, ok := f.orgs[orgID]
+	if !ok {
+		return &APIError{Operation: "core/update", Code: 100, Message: "Organization::" + orgID + " not found"}
+	}
+	if _, ok := f.orgs[parentID]; !ok {
+		return &APIError{Operation: "core/update", Code: 100, Message: "Organization::" + parentID + " not found"}
+	}
+	o.ParentID = parentID
+	f.orgs[orgID] = o
+	f.Updates++
+	return nil
+}
+
+func (f *FakeClient) FindPersonByEmail(email string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	found := ""
+	for id, p := range f.persons {
+		if strings.EqualFold(p.Email, email) {
+			if found != "" {
+				return "", fmt.Errorf("several Persons share the email %s", email)
+			}
+			found = id
+		}
+	}
+	return found, nil
+}
+
+func (f *FakeClient) CreatePerson(p Person, comment string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p.Name == "" || p.OrgID == "" {
+		return "", &APIError{Operation: "core/create", Code: 100, Message: "Person name and org_id are mandatory"}
+	}
+	f.nextID++
+	id := strconv.Itoa(f.nextID)
+	f.persons[id] = p
+	f.Creates++
+	return id, nil
+}
+
+func (f *FakeClient) CreateUserLDAP(login, contactID, profile, comment string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.users[login]; exists {
+		return "", &APIError{Operation: "core/create", Code: 100, Message: "login " + login + " already in use"}
+	}
+	f.nextID++
+	f.users[login] = contactID
+	f.Creates++
+	return strconv.Itoa(f.nextID), nil
+}
+
+// SetPersonField seeds a single attribute of a Person
+func (f *FakeClient) SetPersonField(id, field, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.personFields[id] == nil {
+		f.personFields[id] = make(map[string]string)
+	}
+	f.personFields[id][field] = value
+}
+
+func (f *FakeClient) GetPerson(id string, fields []string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.persons[id]
+	if !ok {
+		return nil, fmt.Errorf("Person::%s not found in iTop", id)
+	}
+	values := make(map[string]string, len(fields))
+	for _, name := range fields {
+		switch name {
+		case "name":
+			values[name] = p.Name
+		case "first_name":
+			values[name] = p.FirstName
+		case "email":
+			values[name] = p.Email
+		case "phone":
+			values[name] = p.Phone
+		case "org_id":
+			values[name] = p.OrgID
+		default:
+			values[name] = f.personFields[id][name]
+		}
+	}
+	return values, nil
+}
+
+func (f *FakeClient) UpdatePerson(id string, fields map[string]string, comment string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.persons[id]
+	if !ok {
+		return &APIError{Operation: "core/update", Code: 100, Message: "Person::" + id + " not found"}
+	}
+	for name, value := range fields {
+		switch name {
+		case "name":
+			p.Name = value
+		case "first_name":
+			p.FirstName = value
+		case "email":
+			p.Email = value
+		case "phone":
+			p.Phone = value
+		case "org_id":
+			p.OrgID = value
+		default:
+			if f.personFields[id] == nil {
+				f.personFields[id] = make(map[string]string)
+			}
+			f.personFields[id][name] = value
+		}
+	}
+	f.persons[id] = p
+	f.Updates++
+	return nil
+}
+
+var (
+	_ API = (*ITopClient)(nil)
+	_ API = (*FakeClient)(nil)
+)
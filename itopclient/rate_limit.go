@@ -0,0 +1,29 @@
+package itopclient
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces calls evenly so that at most perSecond start in any second
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the caller may send the next request
+func (l *rateLimiter) wait(perSecond float64) {
+	if perSecond <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / perSecond)
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
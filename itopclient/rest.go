@@ -0,0 +1,210 @@
+package itopclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ldap-itop/metrics"
+)
+
+// Object is one object returned by the iTop REST API
+type Object struct {
+	Class  string
+	Key    string
+	Fields map[string]interface{}
+}
+
+// String returns a field as a string; numbers are formatted without decimals and
+// missing or non-scalar fields are empty
+func (o Object) String(field string) string {
+	return fieldString(o.Fields, field)
+}
+
+// LinkSet returns the entries of a linkset field such as persons_list
+func (o Object) LinkSet(field string) []map[string]interface{} {
+	raw, _ := o.Fields[field].([]interface{})
+	links := make([]map[string]interface{}, 0, len(raw))
+	for _, r := range raw {
+		if m, ok := r.(map[string]interface{}); ok {
+			links = append(links, m)
+		}
+	}
+	return links
+}
+
+type objectsResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Objects map[string]struct {
+		Code    int                    `json:"code"`
+		Message string                 `json:"message"`
+		Class   string                 `json:"class"`
+		Key     interface{}            `json:"key"`
+		Fields  map[string]interface{} `json:"fields"`
+	} `json:"objects"`
+	Authorized bool `json:"authorized"`
+}
+
+// call posts an operation, decodes the response and checks its code
+func (c *ITopClient) call(operation string, params map[string]interface{}) (*objectsResponse, error) {
+	resp, err := c.Post(operation, params)
+	if err != nil {
+		metrics.ITopAPIErrors.Inc(operation)
+		return nil, err
+	}
+	var result objectsResponse
+	if err := json.Unmarshal(resp, &result); err != nil {
+		metrics.ITopAPIErrors.Inc(operation)
+		return nil, &DecodeError{Operation: operation, Err: err, Body: string(resp)}
+	}
+	if result.Code != 0 {
+		metrics.ITopAPIErrors.Inc(operation)
+		return nil, &APIError{Operation: operation, Code: result.Code, Message: result.Message}
+	}
+	for _, obj := range result.Objects {
+		if obj.Code != 0 {
+			metrics.ITopAPIErrors.Inc(operation)
+			return nil, &APIError{Operation: operation, Code: obj.Code, Message: obj.Message}
+		}
+	}
+	return &result, nil
+}
+
+// objects returns the objects of a response ordered by key
+func (r *objectsResponse) objects() []Object {
+	names := make([]string, 0, len(r.Objects))
+	for name := range r.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	objs := make([]Object, 0, len(names))
+	for _, name := range names {
+		raw := r.Objects[name]
+		obj := Object{Class: raw.Class, Fields: raw.Fields}
+		obj.Key = fieldString(map[string]interface{}{"key": raw.Key}, "key")
+		// Older iTop versions leave class/key out; they are in the "Class::key" name
+		if class, key, ok := strings.Cut(name, "::"); ok {
+			if obj.Class == "" {
+				obj.Class = class
+			}
+			if obj.Key == "" {
+				obj.Key = key
+			}
+		}
+		if obj.Fields == nil {
+			obj.Fields = map[string]interface{}{}
+		}
+		objs = append(objs, obj)
+	}
+	return objs
+}
+
+// single returns the only object of a write operation
+func (r *objectsResponse) single(operation, class string) (Object, error) {
+	objs := r.objects()
+	if len(objs) != 1 {
+		return Object{}, fmt.Errorf("iTop %s on %s returned %d objects, expected 1", operation, class, len(objs))
+	}
+	return objs[0], nil
+}
+
+func outputFields(fields []string) string {
+	if len(fields) == 0 {
+		return "id"
+	}
+	return strings.Join(fields, ",")
+}
+
+// Get runs core/get. key is an object id or an OQL query; fields lists the output
+// fields ("id" when empty, "*" for all).
+func (c *ITopClient) Get(class, key string, fields []string) ([]Object, error) {
+	result, err := c.call("core/get", map[string]interface{}{
+		"class":         class,
+		"key":           key,
+		"output_fields": outputFields(fields),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.objects(), nil
+}
+
+// Create runs core/create and returns the new object with the requested output fields
+func (c *ITopClient) Create(class string, fields map[string]interface{}, comment string, output ...string) (Object, error) {
+	result, err := c.call("core/create", map[string]interface{}{
+		"class":         class,
+		"comment":       comment,
+		"output_fields": outputFields(output),
+		"fields":        fields,
+	})
+	if err != nil {
+		return Object{}, err
+	}
+	return result.single("core/create", class)
+}
+
+// Update runs core/update on the object identified by key (an id, or an OQL query
+// matching exactly one object) and returns it with the requested output fields
+func (c *ITopClient) Update(class, key string, fields map[string]interface{}, comment string, output ...string) (Object, error) {
+	result, err := c.call("core/update", map[string]interface{}{
+		"class":         class,
+		"key":           key,
+		"comment":       comment,
+		"output_fields": outputFields(output),
+		"fields":        fields,
+	})
+	if err != nil {
+		return Object{}, err
+	}
+	return result.single("core/update", class)
+}
+
+// Delete runs core/delete on every object matched by key and returns the deleted
+// objects. iTop also reports objects deleted or updated by cascade.
+func (c *ITopClient) Delete(class, key, comment string) ([]Object, error) {
+	result, err := c.call("core/delete", map[string]interface{}{
+		"class":    class,
+		"key":      key,
+		"comment":  comment,
+		"simulate": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.objects(), nil
+}
+
+// ApplyStimulus applies a lifecycle stimulus (e.g. "ev_resolve") to one object,
+// setting fields first, and returns it with the requested output fields
+func (c *ITopClient) ApplyStimulus(class, key, stimulus string, fields map[string]interface{}, comment string, output ...string) (Object, error) {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	result, err := c.call("core/apply_stimulus", map[string]interface{}{
+		"class":         class,
+		"key":           key,
+		"stimulus":      stimulus,
+		"comment":       comment,
+		"output_fields": outputFields(output),
+		"fields":        fields,
+	})
+	if err != nil {
+		return Object{}, err
+	}
+	return result.single("core/apply_stimulus", class)
+}
+
+// CheckCredentials runs core/check_credentials for the given login and password and
+// reports whether iTop accepts them
+func (c *ITopClient) CheckCredentials(login, password string) (bool, error) {
+	result, err := c.call("core/check_credentials", map[string]interface{}{
+		"user":     login,
+		"password": password,
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.Authorized, nil
+}
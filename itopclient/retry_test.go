@@ -0,0 +1,100 @@
+package itopclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	dialErr := &TransportError{Operation: "core/create", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	readErr := &TransportError{Operation: "core/create", Err: &net.OpError{Op: "read", Err: errors.New("connection reset")}}
+	certErr := &TransportError{Operation: "core/get", Err: &tls.CertificateVerificationError{Err: errors.New("unknown authority")}}
+	status := func(code int) error { return &HTTPStatusError{StatusCode: code} }
+
+	tests := []struct {
+		operation string
+		err       error
+		expected  bool
+	}{
+		{"core/get", readErr, true},
+		{"core/get", status(502), true},
+		{"core/get", status(500), true},
+		{"core/get", status(404), false},
+		{"core/get", certErr, false},
+		{"core/get", &APIError{Code: 100}, false},
+		{"core/get", &DecodeError{Err: errors.New("bad json")}, false},
+		{"core/update", status(504), true},
+		// A create that may have reached iTop is never repeated
+		{"core/create", readErr, false},
+		{"core/create", status(502), false},
+		{"core/create", dialErr, true},
+		{"core/create", status(503), true},
+		{"core/create", status(429), true},
+		{"core/get", fmt.Errorf("wrapped: %w", status(503)), true},
+		// Neither is a stimulus or a delete
+		{"core/apply_stimulus", readErr, false},
+		{"core/apply_stimulus", status(502), false},
+		{"core/apply_stimulus", status(503), true},
+		{"core/delete", status(500), false},
+		{"core/delete", dialErr, true},
+		{"core/check_credentials", status(502), true},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.operation, tt.err); got != tt.expected {
+			t.Errorf("retryable(%s, %v) = %v, want %v", tt.operation, tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &ITopClient{RetryBaseDelay: 100 * time.Millisecond}
+	for attempt := 0; attempt < 20; attempt++ {
+		full := maxRetryDelay
+		if attempt < 16 && c.RetryBaseDelay<<uint(attempt) < maxRetryDelay {
+			full = c.RetryBaseDelay << uint(attempt)
+		}
+		for i := 0; i < 50; i++ {
+			// Equal jitter: between half and the whole exponential delay
+			if d := c.backoff(attempt); d < full/2 || d > full {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, d, full/2, full)
+			}
+		}
+	}
+	if d := (&ITopClient{}).backoff(0); d < defaultRetryBaseDelay/2 || d > defaultRetryBaseDelay {
+		t.Errorf("backoff without a base delay = %s, want around %s", d, defaultRetryBaseDelay)
+	}
+}
+
+func TestPostRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"message":"","objects":{"Team::1":{"code":0,"message":"","class":"Team","key":"1","fields":{"id":"1","name":"DIGI"}}}}`)
+	}))
+	defer srv.Close()
+
+	c := &ITopClient{BaseURL: srv.URL, Username: "u", Version: "1.3", MaxRetries: 2, RetryBaseDelay: time.Millisecond}
+	teams, err := c.GetTeams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(teams) != 1 || teams[0].Name != "DIGI" || calls != 2 {
+		t.Errorf("teams = %+v after %d call(s), want DIGI after a retry", teams, calls)
+	}
+
+	// core/create is not repeated after a 502
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.CreateTeam("FINANCE", "1", ""); err == nil || calls != 1 {
+		t.Errorf("CreateTeam = %v after %d call(s), want the 502 after a single call", err, calls)
+	}
+}
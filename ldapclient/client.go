@@ -1,10 +1,11 @@
 package ldapclient
 
 import (
-	"os"
+	"fmt"
+	"log"
+	"net/url"
 
 	"github.com/go-ldap/ldap/v3"
-	"github.com/joho/godotenv"
 )
 
 // LDAPClient wraps the ldap.Conn
@@ -12,21 +13,33 @@ type LDAPClient struct {
 	Conn *ldap.Conn
 }
 
-// NewLDAPClient creates and authenticates a new LDAP client
-func NewLDAPClient() (*LDAPClient, error) {
-	// Load .env file
-	_ = godotenv.Load()
-
-	ldapURL := os.Getenv("LDAP_URL")
-	bindUser := os.Getenv("LDAP_BIND_USER") // username only
-	bindPassword := os.Getenv("LDAP_BIND_PASSWORD")
-
-	l, err := ldap.DialURL(ldapURL)
+// Dial connects and binds to the directory of a single source, over TLS for
+// ldaps:// URLs or when StartTLS is set
+func Dial(src Source) (*LDAPClient, error) {
+	u, err := url.Parse(src.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %q: %w", src.URL, err)
+	}
+	tlsConfig, err := src.TLS.Config(u.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings for LDAP source %q: %w", src.Name, err)
+	}
+	if src.TLS.InsecureSkipVerify {
+		log.Printf("[WARN] TLS certificate verification is disabled for LDAP source %q.", src.Name)
+	}
+	l, err := ldap.DialURL(src.URL, ldap.DialWithTLSConfig(tlsConfig))
 	if err != nil {
 		return nil, err
 	}
 
-	err = l.Bind(bindUser, bindPassword)
+	if src.StartTLS {
+		if err := l.StartTLS(tlsConfig); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+
+	err = l.Bind(src.BindUser, src.BindPassword)
 	if err != nil {
 		l.Close()
 		return nil, err
@@ -0,0 +1,82 @@
+package ldapclient
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestChangedSinceFilter(t *testing.T) {
+	tests := []struct {
+		filter, attr, since string
+		expected            string
+	}{
+		{"(objectClass=user)", ChangeAttrUSN, "1000", "(&(objectClass=user)(uSNChanged>=1001))"},
+		{"(objectClass=user)", ChangeAttrWhenChanged, "20240601120000.0Z", "(&(objectClass=user)(whenChanged>=20240601120000.0Z))"},
+		{"", ChangeAttrUSN, "5", "(&" + DefaultUserFilter + "(uSNChanged>=6))"},
+	}
+	for _, tt := range tests {
+		got, err := ChangedSinceFilter(tt.filter, tt.attr, tt.since)
+		if err != nil {
+			t.Errorf("ChangedSinceFilter(%q, %q, %q): %v", tt.filter, tt.attr, tt.since, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ChangedSinceFilter(%q, %q, %q) = %s, want %s", tt.filter, tt.attr, tt.since, got, tt.expected)
+		}
+	}
+
+	if _, err := ChangedSinceFilter("", ChangeAttrUSN, "abc"); err == nil {
+		t.Error("an invalid uSNChanged marker should be an error")
+	}
+	if _, err := ChangedSinceFilter("", "modifyTimestamp", "1"); err == nil {
+		t.Error("an unsupported change attribute should be an error")
+	}
+}
+
+func entries(attr string, values ...string) []*ldap.Entry {
+	var list []*ldap.Entry
+	for _, v := range values {
+		list = append(list, ldap.NewEntry("CN=x", map[string][]string{attr: {v}}))
+	}
+	return list
+}
+
+func TestHighestChange(t *testing.T) {
+	// uSNChanged compares as a number, not as a string
+	if got := HighestChange(entries(ChangeAttrUSN, "99", "1000", "250"), ChangeAttrUSN, "500"); got != "1000" {
+		t.Errorf("highest uSNChanged = %s, want 1000", got)
+	}
+	if got := HighestChange(entries(ChangeAttrUSN, "99"), ChangeAttrUSN, "500"); got != "500" {
+		t.Errorf("highest uSNChanged = %s, want the current 500", got)
+	}
+	if got := HighestChange(entries(ChangeAttrUSN, "7", ""), ChangeAttrUSN, ""); got != "7" {
+		t.Errorf("highest uSNChanged without a marker = %s, want 7", got)
+	}
+	got := HighestChange(entries(ChangeAttrWhenChanged, "20240601120000.0Z", "20231231235959.0Z"), ChangeAttrWhenChanged, "20240101000000.0Z")
+	if got != "20240601120000.0Z" {
+		t.Errorf("highest whenChanged = %s", got)
+	}
+}
+
+func TestSyncStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sync-state.json")
+	state, err := LoadSyncState(path)
+	if err != nil || len(state) != 0 {
+		t.Fatalf("missing state file = %v, %v; want an empty state", state, err)
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	state["corp"] = SourceState{HighestChange: "1000", ChangeAttr: ChangeAttrUSN, LastFullSync: now, LastSync: now}
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSyncState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded["corp"]; got.HighestChange != "1000" || !got.LastFullSync.Equal(now) {
+		t.Errorf("loaded state = %+v", got)
+	}
+}
@@ -0,0 +1,86 @@
+package ldapclient
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// DefaultUserFilter selects user accounts that belong to a person
+const DefaultUserFilter = "(&(objectClass=user)(objectCategory=person))"
+
+// DefaultPageSize stays below the usual AD MaxPageSize of 1000
+const DefaultPageSize = 500
+
+// SearchConfig describes which entries are read from the directory
+type SearchConfig struct {
+	BaseDNs    []string
+	Filter     string
+	Attributes []string
+	PageSize   uint32
+}
+
+// SearchPaged runs the search under every base DN using the SimplePagedResults
+// control, so results are not truncated at the server's size limit. Entries found
+// under more than one base DN are returned once.
+func (c *LDAPClient) SearchPaged(cfg SearchConfig) ([]*ldap.Entry, error) {
+	pageSize := cfg.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	filter := cfg.Filter
+	if filter == "" {
+		filter = DefaultUserFilter
+	}
+	seen := make(map[string]bool)
+	var entries []*ldap.Entry
+	for _, baseDN := range cfg.BaseDNs {
+		searchRequest := ldap.NewSearchRequest(
+			baseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			filter,
+			cfg.Attributes,
+			nil,
+		)
+		sr, err := c.Conn.SearchWithPaging(searchRequest, pageSize)
+		if err != nil {
+			return nil, fmt.Errorf("search under %s failed: %w", baseDN, err)
+		}
+		for _, e := range sr.Entries {
+			key := strings.ToLower(e.DN)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// ReadEntry reads a single entry with a base-object search. An entry that does not
+// exist or does not match filter is returned as nil without error.
+func (c *LDAPClient) ReadEntry(dn, filter string, attributes []string) (*ldap.Entry, error) {
+	if filter == "" {
+		filter = DefaultUserFilter
+	}
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+	sr, err := c.Conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read of %s failed: %w", dn, err)
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+	return sr.Entries[0], nil
+}
@@ -0,0 +1,121 @@
+package ldapclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"gopkg.in/yaml.v2"
+
+	"ldap-itop/helper"
+)
+
+// Source is one directory (an AD forest or domain) users are read from
+type Source struct {
+	Name         string `yaml:"Name"`
+	URL          string `yaml:"URL"`
+	BindUser     string `yaml:"BindUser"`
+	BindPassword string `yaml:"BindPassword,omitempty"`
+	// BindPasswordEnv names an env var holding the password, to keep it out of the file
+	BindPasswordEnv string   `yaml:"BindPasswordEnv,omitempty"`
+	BaseDNs         []string `yaml:"BaseDNs"`
+	Filter          string   `yaml:"Filter,omitempty"`
+	PageSize        uint32   `yaml:"PageSize,omitempty"`
+	// ITopOrgID is the iTop organization users of this source belong to; empty means ITOP_ORG_ID
+	ITopOrgID string `yaml:"ITopOrgID,omitempty"`
+	// StartTLS upgrades an ldap:// connection to TLS before binding
+	StartTLS bool `yaml:"StartTLS,omitempty"`
+	// TLS applies to ldaps:// URLs and StartTLS
+	TLS helper.TLSOptions `yaml:"TLS,omitempty"`
+}
+
+// LoadSources reads a YAML list of sources. Sources are searched in file order,
+// which also decides which entry is kept when a user appears in several sources.
+// The sources are not validated; see ValidateSources.
+func LoadSources(path string) ([]Source, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	if err := yaml.Unmarshal(data, &sources); err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no LDAP sources defined in %s", path)
+	}
+	ResolveSources(sources)
+	return sources, nil
+}
+
+// ResolveSources fills in default names and reads passwords given by BindPasswordEnv
+func ResolveSources(sources []Source) {
+	for i := range sources {
+		if sources[i].BindPasswordEnv != "" {
+			sources[i].BindPassword = os.Getenv(sources[i].BindPasswordEnv)
+		}
+		if sources[i].Name == "" {
+			sources[i].Name = fmt.Sprintf("source-%d", i+1)
+		}
+	}
+}
+
+// ValidateSources returns every problem found in the sources
+func ValidateSources(sources []Source) []error {
+	var errs []error
+	if len(sources) == 0 {
+		errs = append(errs, fmt.Errorf("no LDAP source configured"))
+	}
+	names := make(map[string]bool)
+	for _, src := range sources {
+		if names[src.Name] {
+			errs = append(errs, fmt.Errorf("duplicate LDAP source name %q", src.Name))
+		}
+		names[src.Name] = true
+		if err := src.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Validate checks the settings of a single source
+func (s Source) Validate() error {
+	if s.URL == "" {
+		return fmt.Errorf("LDAP source %q has no URL", s.Name)
+	}
+	if len(s.BaseDNs) == 0 {
+		return fmt.Errorf("LDAP source %q has no base DN", s.Name)
+	}
+	if s.StartTLS && strings.HasPrefix(strings.ToLower(s.URL), "ldaps://") {
+		return fmt.Errorf("LDAP source %q uses ldaps://, StartTLS is only for ldap:// URLs", s.Name)
+	}
+	return nil
+}
+
+// Contains tells whether dn lies under one of the base DNs of this source
+func (s Source) Contains(dn string) bool {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return false
+	}
+	for _, base := range s.BaseDNs {
+		b, err := ldap.ParseDN(base)
+		if err == nil && (b.EqualFold(parsed) || b.AncestorOfFold(parsed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchConfig returns the search settings of this source for the given attributes
+func (s Source) SearchConfig(attributes []string) SearchConfig {
+	return SearchConfig{
+		BaseDNs:    s.BaseDNs,
+		Filter:     s.Filter,
+		Attributes: attributes,
+		PageSize:   s.PageSize,
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
-	"io/ioutil"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -12,11 +14,10 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/tealeg/xlsx"
 
-	"ldap-itop/helper"
+	"ldap-itop/config"
 	"ldap-itop/itopclient"
 	"ldap-itop/ldapclient"
 	"ldap-itop/parser"
-	"ldap-itop/synchronizer"
 )
 
 func toXLSX(csvData []byte) []byte {
@@ -35,135 +36,193 @@ func toXLSX(csvData []byte) []byte {
 	return buf.Bytes()
 }
 
-func initItopClient() (*itopclient.ITopClient, string) {
-	itopURL := os.Getenv("ITOP_API_URL")
-	itopUser := os.Getenv("ITOP_API_USER")
-	itopPwd := os.Getenv("ITOP_API_PWD")
-	itopVersion := os.Getenv("ITOP_VERSION")
-	orgID := os.Getenv("ITOP_ORG_ID")
-	client := &itopclient.ITopClient{BaseURL: itopURL, Username: itopUser, Password: itopPwd, Version: itopVersion}
-	return client, orgID
-}
-
-func buildEmailBody(hasDeptErr, hasUserErr bool) string {
-	body := "Dear Team,\n\nBerikut adalah hasil error sinkronisasi user dan departmentnya dari AD ke iTop:\n"
-	if hasDeptErr {
-		body += "- Terdapat Department Validation Errors (Adanya department pada user yang tidak valid)\n"
+// newItopClient builds the iTop client from the configuration
+func newItopClient(cfg config.ITopConfig) (*itopclient.ITopClient, error) {
+	tlsConfig, err := cfg.TLS.Config("")
+	if err != nil {
+		return nil, fmt.Errorf("invalid iTop TLS settings: %w", err)
 	}
-	if hasUserErr {
-		body += "- User Not Synchronized Errors (Adanya user yang gagal dalam proses syncronization dari AD ke iTop)\n"
+	if cfg.TLS.InsecureSkipVerify {
+		log.Println("[WARN] TLS certificate verification is disabled for iTop.")
 	}
-	body += "\nSilakan periksa lampiran untuk detail lebih lanjut.\n\nBest regards,\nDevOps Team"
-	return body
+	return &itopclient.ITopClient{
+		BaseURL:        cfg.URL,
+		Username:       cfg.User,
+		Password:       cfg.Password,
+		Version:        cfg.Version,
+		AuthMode:       cfg.AuthMode,
+		Token:          cfg.Token,
+		RateLimit:      cfg.RateLimit,
+		MaxRetries:     cfg.MaxRetries,
+		RetryBaseDelay: cfg.RetryBaseDelay,
+		Timeout:        cfg.Timeout,
+		TLS:            tlsConfig,
+	}, nil
 }
 
-func main() {
-	_ = godotenv.Load()
-	baseDN := os.Getenv("LDAP_BASE_DN")
+// changeScan says how a source is read when incremental sync is enabled
+type changeScan struct {
+	Attr  string // change attribute to track; empty when incremental sync is off
+	Since string // only read entries changed after this marker; empty reads everything
+}
 
-	client, err := ldapclient.NewLDAPClient()
+// fetchSourceUsers reads the users of one source and returns them with the highest
+// change marker seen (the previous marker when nothing changed)
+func fetchSourceUsers(src ldapclient.Source, attrMap parser.AttributeMap, scan changeScan) ([]parser.User, string, error) {
+	client, err := ldapclient.Dial(src)
 	if err != nil {
-		log.Fatalf("[Error] LDAP auth failed: %v", err)
+		return nil, "", fmt.Errorf("LDAP auth failed: %w", err)
 	}
 	defer client.Close()
-	log.Println("[OK] LDAP authentication successful.")
-
-	searchRequest := ldap.NewSearchRequest(
-		baseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=user)(objectCategory=person))",
-		[]string{"cn", "mail", "sAMAccountName", "department"},
-		nil,
-	)
+	log.Printf("[OK] LDAP authentication to '%s' successful.", src.Name)
 
-	sr, err := client.Conn.Search(searchRequest)
+	searchCfg := src.SearchConfig(attrMap.Attributes())
+	if scan.Attr != "" {
+		searchCfg.Attributes = append(searchCfg.Attributes, scan.Attr)
+	}
+	if scan.Since != "" {
+		if searchCfg.Filter, err = ldapclient.ChangedSinceFilter(searchCfg.Filter, scan.Attr, scan.Since); err != nil {
+			return nil, "", err
+		}
+	}
+	entries, err := client.SearchPaged(searchCfg)
 	if err != nil {
-		log.Fatalf("Search failed: %v", err)
+		return nil, "", fmt.Errorf("search failed: %w", err)
+	}
+	if scan.Since != "" {
+		log.Printf("[OK] Fetched %d user(s) changed since %s=%s from '%s'.", len(entries), scan.Attr, scan.Since, src.Name)
+	} else {
+		log.Printf("[OK] Fetched %d user(s) from '%s' (%d base DN(s)).", len(entries), src.Name, len(src.BaseDNs))
 	}
 
-	users := parser.ParseUsers(sr.Entries)
-
-	// Validate and assign department, write CSV reports
-	yamlPath := "data/valid-department-list.yaml"
-	usersOut := "output/users.csv"
-	reportOut := "output/dept-validation-errors-report.csv"
-	if err := os.MkdirAll("output", os.ModePerm); err != nil {
-		log.Fatalf("Failed create output dir: %v", err)
+	users := parser.ParseUsersWithMap(entries, attrMap)
+	for i := range users {
+		users[i].Source = src.Name
+		users[i].OrgID = src.ITopOrgID
+	}
+	marker := ""
+	if scan.Attr != "" {
+		marker = ldapclient.HighestChange(entries, scan.Attr, scan.Since)
 	}
-	threshold := 1.00 // Jaro-Winkler similarity threshold
-	err = parser.ValidateAndAssignDepartment(users, yamlPath, usersOut, reportOut, threshold)
+	return users, marker, nil
+}
+
+// fetchUsersByDN reads single users of one source by DN; DNs that do not exist or
+// do not match the source filter are left out
+func fetchUsersByDN(src ldapclient.Source, attrMap parser.AttributeMap, dns []string) ([]parser.User, error) {
+	client, err := ldapclient.Dial(src)
 	if err != nil {
-		log.Fatalf("[Error] Department validation failed: %v", err)
-	}
-	log.Println("[OK] Department validation complete.")
-
-	// Cek isi dept-validation-errors-report.csv
-	deptHasData := false
-	reportBytes, _ := ioutil.ReadFile(reportOut)
-	if len(reportBytes) > 0 {
-		reader := csv.NewReader(strings.NewReader(string(reportBytes)))
-		records, _ := reader.ReadAll()
-		if len(records) > 1 {
-			deptHasData = true
+		return nil, fmt.Errorf("LDAP auth failed: %w", err)
+	}
+	defer client.Close()
+
+	var entries []*ldap.Entry
+	for _, dn := range dns {
+		entry, err := client.ReadEntry(dn, src.Filter, attrMap.Attributes())
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
 		}
 	}
-	var deptXlsx []byte
-	if deptHasData {
-		deptXlsx = toXLSX(reportBytes)
+	users := parser.ParseUsersWithMap(entries, attrMap)
+	for i := range users {
+		users[i].Source = src.Name
+		users[i].OrgID = src.ITopOrgID
 	}
+	return users, nil
+}
 
-	// Sync teams/department and users to iTop
-	itopClient, orgID := initItopClient()
-	// Test iTop authentication
-	authErr := itopClient.Authenticate()
-	if authErr != nil {
-		log.Fatalf("[Error] iTop authentication failed: %v", authErr)
-	} else {
-		log.Println("[OK] iTop authentication successful.")
+func buildEmailBody(hasDeptErr, hasUserErr, hasDupErr bool) string {
+	body := "Dear Team,\n\nBerikut adalah hasil error sinkronisasi user dan departmentnya dari AD ke iTop:\n"
+	if hasDeptErr {
+		body += "- Terdapat Department Validation Errors (Adanya department pada user yang tidak valid)\n"
 	}
-	err = synchronizer.SyncTeamsToItop(yamlPath, itopClient, orgID)
-	if err != nil {
-		log.Fatalf("[Error] Team/Department sync failed: %v", err)
+	if hasUserErr {
+		body += "- User Not Synchronized Errors (Adanya user yang gagal dalam proses syncronization dari AD ke iTop)\n"
 	}
-	log.Println("[OK] Teams/Departments synced successfully.")
+	if hasDupErr {
+		body += "- Duplicate LDAP Users (Adanya user dengan sAMAccountName/email yang sama di lebih dari satu sumber LDAP)\n"
+	}
+	body += "\nSilakan periksa lampiran untuk detail lebih lanjut.\n\nBest regards,\nDevOps Team"
+	return body
+}
 
-	notSyncedCSV := "output/user-not-synchronized.csv"
-	err = synchronizer.SyncUsersToTeams(usersOut, yamlPath, notSyncedCSV, itopClient)
-	if err != nil {
-		log.Fatalf("[Error] User sync failed: %v", err)
+// configPath returns the config file to read: the --config flag, then CONFIG_FILE,
+// then config.yaml when it exists. Empty means env vars only.
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
 	}
-	log.Println("[OK] Users synced successfully.")
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return "config.yaml"
+	}
+	return ""
+}
 
-	userHasData := false
-	notSyncedBytes, _ := ioutil.ReadFile(notSyncedCSV)
-	if len(notSyncedBytes) > 0 {
-		reader := csv.NewReader(strings.NewReader(string(notSyncedBytes)))
-		records, _ := reader.ReadAll()
-		if len(records) > 1 {
-			userHasData = true
+// validateConfig implements "config validate": it prints every problem and fails
+// when there is at least one
+func validateConfig(path string) error {
+	_, err := config.Load(path, config.NeedAll)
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		for _, p := range verr.Problems {
+			fmt.Println("[ERROR] " + p)
 		}
+		return fmt.Errorf("%d configuration problem(s) found", len(verr.Problems))
+	}
+	if err != nil {
+		return err
 	}
-	var userXlsx []byte
-	if userHasData {
-		userXlsx = toXLSX(notSyncedBytes)
+	if path == "" {
+		path = "env vars only"
 	}
+	log.Printf("[OK] Configuration is valid (%s).", path)
+	return nil
+}
 
-	// Send email only if ada data error
-	if deptHasData || userHasData {
-		subject := os.Getenv("EMAIL_SUBJECT")
-		body := buildEmailBody(deptHasData, userHasData)
-		attachments := map[string][]byte{}
-		if deptHasData {
-			attachments["dept-validation-errors-report.xlsx"] = deptXlsx
-		}
-		if userHasData {
-			attachments["user-not-synchronized.xlsx"] = userXlsx
+func main() {
+	dryRun := flag.Bool("dry-run", false, "Read from LDAP and iTop but only write a plan report instead of changing iTop or the department YAML")
+	fullSync := flag.Bool("full-sync", false, "Ignore the incremental sync state and read every user")
+	configFlag := flag.String("config", "", "YAML config file (default $CONFIG_FILE or config.yaml); env vars override it")
+	flag.Usage = usage
+	flag.Parse()
+	opts := runOptions{DryRun: *dryRun, FullSync: *fullSync}
+
+	_ = godotenv.Load()
+	path := configPath(*configFlag)
+
+	name := flag.Arg(0)
+	if name == "config" {
+		if flag.Arg(1) != "validate" {
+			log.Fatalf("[Error] Unknown config command %q, expected \"config validate\"", flag.Arg(1))
 		}
-		err := helper.SendErrorMail(subject, body, attachments)
-		if err != nil {
-			log.Printf("[Error] Failed to send email: %v", err)
-		} else {
-			log.Println("[OK] Email sent successfully.")
+		if err := validateConfig(path); err != nil {
+			log.Fatalf("[Error] %v", err)
 		}
+		return
+	}
+	var args []string
+	if name == "" {
+		name = "run"
+	} else {
+		args = flag.Args()[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(path, cmd.needs)
+	if err != nil {
+		log.Fatalf("[Error] %v", err)
+	}
+	if err := cmd.run(cfg, opts, args); err != nil {
+		log.Fatalf("[Error] %v", err)
 	}
 }
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric is a counter or gauge, optionally split by a single label
+type Metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge"
+	label string
+
+	mu     sync.Mutex
+	values map[string]float64 // label value -> value; "" when unlabelled
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Metric
+)
+
+func newMetric(name, help, kind, label string) *Metric {
+	m := &Metric{name: name, help: help, kind: kind, label: label, values: make(map[string]float64)}
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+	return m
+}
+
+// NewCounter registers a counter; label may be empty for an unlabelled counter
+func NewCounter(name, help, label string) *Metric {
+	return newMetric(name, help, "counter", label)
+}
+
+// NewGauge registers a gauge; label may be empty for an unlabelled gauge
+func NewGauge(name, help, label string) *Metric {
+	return newMetric(name, help, "gauge", label)
+}
+
+// Add increases the value for the given label values (none for unlabelled metrics)
+func (m *Metric) Add(v float64, labelValue ...string) {
+	key := strings.Join(labelValue, "")
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+func (m *Metric) Inc(labelValue ...string) {
+	m.Add(1, labelValue...)
+}
+
+// Set replaces the value, for gauges
+func (m *Metric) Set(v float64, labelValue ...string) {
+	key := strings.Join(labelValue, "")
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+func (m *Metric) sampleName(key string) string {
+	if m.label == "" {
+		return m.name
+	}
+	return fmt.Sprintf("%s{%s=%q}", m.name, m.label, key)
+}
+
+// Snapshot returns the current value of every sample, keyed by sample name
+func Snapshot() map[string]float64 {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	snap := make(map[string]float64)
+	for _, m := range registry {
+		m.mu.Lock()
+		for k, v := range m.values {
+			snap[m.sampleName(k)] = v
+		}
+		m.mu.Unlock()
+	}
+	return snap
+}
+
+// CounterDiff returns how much each counter grew since an earlier Snapshot,
+// leaving out counters that did not change
+func CounterDiff(before map[string]float64) map[string]float64 {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	diff := make(map[string]float64)
+	for _, m := range registry {
+		if m.kind != "counter" {
+			continue
+		}
+		m.mu.Lock()
+		for k, v := range m.values {
+			name := m.sampleName(k)
+			if d := v - before[name]; d != 0 {
+				diff[name] = d
+			}
+		}
+		m.mu.Unlock()
+	}
+	return diff
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format
+func WritePrometheus(w io.Writer) {
+	registryMu.Lock()
+	metrics := append([]*Metric(nil), registry...)
+	registryMu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 && m.label == "" {
+			fmt.Fprintf(w, "%s 0\n", m.name)
+		}
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s %g\n", m.sampleName(k), m.values[k])
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
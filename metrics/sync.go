@@ -0,0 +1,17 @@
+package metrics
+
+// Metrics of the synchronisation pipeline
+var (
+	LDAPUsersFetched       = NewCounter("ldap_itop_ldap_users_fetched_total", "Users read from LDAP, by source.", "source")
+	DeptValidationFailures = NewCounter("ldap_itop_department_validation_failures_total", "Users whose department could not be matched.", "")
+	TeamsCreated           = NewCounter("ldap_itop_teams_created_total", "Teams created in iTop.", "")
+	OrganizationsCreated   = NewCounter("ldap_itop_organizations_created_total", "Organizations created in iTop.", "")
+	MembershipsAdded       = NewCounter("ldap_itop_team_memberships_added_total", "Persons added to a Team in iTop.", "")
+	MembershipsRemoved     = NewCounter("ldap_itop_team_memberships_removed_total", "Persons removed from a Team in iTop.", "")
+	ITopAPIErrors          = NewCounter("ldap_itop_itop_api_errors_total", "Failed iTop REST calls, by operation.", "operation")
+
+	SyncRuns         = NewCounter("ldap_itop_sync_runs_total", "Completed sync runs, by result.", "result")
+	LastRunTimestamp = NewGauge("ldap_itop_sync_last_run_timestamp_seconds", "End time of the last sync run.", "")
+	LastRunDuration  = NewGauge("ldap_itop_sync_last_run_duration_seconds", "Duration of the last sync run.", "")
+	LastRunSuccess   = NewGauge("ldap_itop_sync_last_run_success", "1 if the last sync run succeeded, 0 otherwise.", "")
+)
@@ -0,0 +1,81 @@
+package parser
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AccountStatus string
+
+const (
+	AccountActive   AccountStatus = "active"
+	AccountDisabled AccountStatus = "disabled"
+	AccountExpired  AccountStatus = "expired"
+)
+
+// uacAccountDisable is the ACCOUNTDISABLE flag of userAccountControl
+const uacAccountDisable = 0x2
+
+// accountNeverExpires is the accountExpires value AD uses for "never"; 0 means the same
+const accountNeverExpires = 9223372036854775807
+
+// ClassifyAccount derives the account status from the raw userAccountControl and
+// accountExpires values. Missing or unparsable values count as active.
+func ClassifyAccount(userAccountControl, accountExpires string, now time.Time) AccountStatus {
+	if uac, err := strconv.ParseInt(strings.TrimSpace(userAccountControl), 10, 64); err == nil && uac&uacAccountDisable != 0 {
+		return AccountDisabled
+	}
+	if exp, err := strconv.ParseInt(strings.TrimSpace(accountExpires), 10, 64); err == nil && exp != 0 && exp != accountNeverExpires {
+		if fileTimeToTime(exp).Before(now) {
+			return AccountExpired
+		}
+	}
+	return AccountActive
+}
+
+// fileTimeToTime converts a Windows FILETIME (100ns intervals since 1601-01-01 UTC)
+func fileTimeToTime(ft int64) time.Time {
+	const epochDiff = 116444736000000000 // 1601-01-01 to 1970-01-01 in 100ns
+	return time.Unix(0, (ft-epochDiff)*100).UTC()
+}
+
+func isActive(u User) bool {
+	return u.Status == AccountActive || u.Status == ""
+}
+
+// SplitActiveUsers separates active users from disabled and expired ones
+func SplitActiveUsers(users []User) (active, inactive []User) {
+	for _, u := range users {
+		if isActive(u) {
+			active = append(active, u)
+		} else {
+			inactive = append(inactive, u)
+		}
+	}
+	return active, inactive
+}
+
+// SaveInactiveUsersToCSV writes disabled and expired users to their own report
+func SaveInactiveUsersToCSV(users []User, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"CN", "Email", "SAMAccountName", "Department", "Account-Status", "Source"}); err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := writer.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department, string(u.Status), u.Source}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
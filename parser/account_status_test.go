@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func fileTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/100+116444736000000000, 10)
+}
+
+func TestClassifyAccount(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		uac      string
+		expires  string
+		expected AccountStatus
+	}{
+		{"normal account", "512", "0", AccountActive},
+		{"never expires", "512", "9223372036854775807", AccountActive},
+		{"disabled", "514", "0", AccountDisabled},
+		{"disabled wins over expired", "514", fileTime(now.Add(-time.Hour)), AccountDisabled},
+		{"expired", "512", fileTime(now.Add(-time.Hour)), AccountExpired},
+		{"expires later", "512", fileTime(now.Add(time.Hour)), AccountActive},
+		{"missing attributes", "", "", AccountActive},
+		{"unparsable attributes", "abc", "xyz", AccountActive},
+	}
+	for _, tt := range tests {
+		if got := ClassifyAccount(tt.uac, tt.expires, now); got != tt.expected {
+			t.Errorf("%s: ClassifyAccount(%q, %q) = %s, want %s", tt.name, tt.uac, tt.expires, got, tt.expected)
+		}
+	}
+}
+
+func TestSplitActiveUsers(t *testing.T) {
+	users := []User{
+		{CN: "a", Status: AccountActive},
+		{CN: "b", Status: AccountDisabled},
+		{CN: "c"},
+		{CN: "d", Status: AccountExpired},
+	}
+	active, inactive := SplitActiveUsers(users)
+	if len(active) != 2 || active[0].CN != "a" || active[1].CN != "c" {
+		t.Errorf("active = %+v, want a and c", active)
+	}
+	if len(inactive) != 2 || inactive[0].CN != "b" || inactive[1].CN != "d" {
+		t.Errorf("inactive = %+v, want b and d", inactive)
+	}
+}
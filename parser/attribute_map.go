@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AttributeMap names the LDAP attribute read for each User field
+type AttributeMap struct {
+	CN             string
+	Email          string
+	SAMAccountName string
+	Department     string
+	FirstName      string
+	LastName       string
+	Phone          string
+	Manager        string // DN of the user's manager
+	// Account state attributes, used to skip disabled and expired accounts
+	UserAccountControl string
+	AccountExpires     string
+	// Extra attributes are copied as-is into User.Attributes
+	Extra []string
+}
+
+func DefaultAttributeMap() AttributeMap {
+	return AttributeMap{
+		CN:             "cn",
+		Email:          "mail",
+		SAMAccountName: "sAMAccountName",
+		Department:     "department",
+		FirstName:      "givenName",
+		LastName:       "sn",
+		Phone:          "telephoneNumber",
+		Manager:        "manager",
+
+		UserAccountControl: "userAccountControl",
+		AccountExpires:     "accountExpires",
+	}
+}
+
+// ParseAttributeMap overrides the default mapping with a spec like
+// "Email=userPrincipalName;Department=division". Unlisted fields keep their default.
+func ParseAttributeMap(spec string) (AttributeMap, error) {
+	m := DefaultAttributeMap()
+	for _, pair := range strings.Split(spec, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, attr, ok := strings.Cut(pair, "=")
+		field, attr = strings.TrimSpace(field), strings.TrimSpace(attr)
+		if !ok || attr == "" {
+			return m, fmt.Errorf("invalid attribute mapping %q, expected Field=ldapAttribute", pair)
+		}
+		switch strings.ToLower(field) {
+		case "cn":
+			m.CN = attr
+		case "email":
+			m.Email = attr
+		case "samaccountname":
+			m.SAMAccountName = attr
+		case "department":
+			m.Department = attr
+		case "firstname":
+			m.FirstName = attr
+		case "lastname":
+			m.LastName = attr
+		case "phone":
+			m.Phone = attr
+		case "manager":
+			m.Manager = attr
+		case "useraccountcontrol":
+			m.UserAccountControl = attr
+		case "accountexpires":
+			m.AccountExpires = attr
+		default:
+			return m, fmt.Errorf("unknown user field %q in attribute mapping", field)
+		}
+	}
+	return m, nil
+}
+
+// Attributes returns the LDAP attributes to request for this mapping
+func (m AttributeMap) Attributes() []string {
+	attrs := []string{m.CN, m.Email, m.SAMAccountName, m.Department, m.FirstName, m.LastName, m.Phone, m.Manager, m.UserAccountControl, m.AccountExpires}
+	return append(attrs, m.Extra...)
+}
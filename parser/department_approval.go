@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx"
+	"gopkg.in/yaml.v2"
+)
+
+// ApprovedAlias is a raw LDAP department that was approved as an alias of a
+// valid department in the validation error report
+type ApprovedAlias struct {
+	Raw        string
+	Department string
+}
+
+// ApprovalResult tells what ApproveDepartmentAliases did with the approved rows
+type ApprovalResult struct {
+	Added []ApprovedAlias
+	// Known are aliases that were already in the list
+	Known []ApprovedAlias
+	// Rejected are aliases that could not be merged
+	Rejected []RejectedAlias
+	// Backup is the copy of the list made before writing; empty when nothing changed
+	Backup string
+}
+
+// RejectedAlias is an approved alias that was not merged, with the reason
+type RejectedAlias struct {
+	ApprovedAlias
+	Reason string
+}
+
+// LoadApprovedAliases reads the rows of a department validation error report
+// (CSV or XLSX) whose "approve" column is set to y, yes, true, x or 1. The raw
+// string is taken from Department and the target from Predicted-Valid-Department,
+// which may be corrected by hand before approving.
+func LoadApprovedAliases(filename string) ([]ApprovedAlias, error) {
+	records, err := readReport(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s is empty", filename)
+	}
+	col := make(map[string]int)
+	for i, h := range records[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := col["approve"]; !ok {
+		if i, ok := col["approved"]; ok {
+			col["approve"] = i
+		}
+	}
+	for _, required := range []string{"approve", "department", "predicted-valid-department"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", filename, required)
+		}
+	}
+
+	var aliases []ApprovedAlias
+	seen := make(map[ApprovedAlias]bool)
+	for _, rec := range records[1:] {
+		field := func(name string) string {
+			if i := col[name]; i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		if !isApproved(field("approve")) {
+			continue
+		}
+		alias := ApprovedAlias{Raw: field("department"), Department: field("predicted-valid-department")}
+		// The same raw department usually appears for many users
+		if seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+func isApproved(value string) bool {
+	switch strings.ToLower(value) {
+	case "y", "yes", "true", "x", "1":
+		return true
+	}
+	return false
+}
+
+// readReport returns the rows of a CSV file, or of the first sheet of an XLSX file
+func readReport(filename string) ([][]string, error) {
+	if !strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r := csv.NewReader(file)
+		// Rows edited in a spreadsheet do not always keep the same number of columns
+		r.FieldsPerRecord = -1
+		return r.ReadAll()
+	}
+
+	file, err := xlsx.OpenFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Sheets) == 0 {
+		return nil, fmt.Errorf("%s has no sheet", filename)
+	}
+	var records [][]string
+	for _, row := range file.Sheets[0].Rows {
+		rec := make([]string, 0, len(row.Cells))
+		for _, cell := range row.Cells {
+			rec = append(rec, cell.String())
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// ApproveDepartmentAliases adds the approved raw departments to the SubList of
+// their department. The order of the list and of every SubList is kept, new
+// aliases are appended, and a timestamped backup of the YAML is written before
+// it is changed.
+func ApproveDepartmentAliases(yamlPath string, aliases []ApprovedAlias) (ApprovalResult, error) {
+	var result ApprovalResult
+	data, err := ioutil.ReadFile(yamlPath)
+	if err != nil {
+		return result, err
+	}
+	var deptList DepartmentYAMLList
+	if err := yaml.Unmarshal(data, &deptList); err != nil {
+		return result, err
+	}
+
+	// owner maps every known name (upper-cased) to the index of its department
+	owner := make(map[string]int)
+	for i, d := range deptList {
+		owner[strings.ToUpper(d.DepartmentName)] = i
+		for _, sub := range d.SubList {
+			if strings.TrimSpace(sub) != "" {
+				owner[strings.ToUpper(sub)] = i
+			}
+		}
+	}
+
+	for _, a := range aliases {
+		if a.Raw == "" {
+			result.Rejected = append(result.Rejected, RejectedAlias{a, "empty department"})
+			continue
+		}
+		target := -1
+		for i, d := range deptList {
+			if strings.EqualFold(d.DepartmentName, a.Department) {
+				target = i
+				break
+			}
+		}
+		if target < 0 {
+			result.Rejected = append(result.Rejected, RejectedAlias{a, fmt.Sprintf("department %q is not in the list", a.Department)})
+			continue
+		}
+		if i, ok := owner[strings.ToUpper(a.Raw)]; ok {
+			if i == target {
+				result.Known = append(result.Known, a)
+			} else {
+				result.Rejected = append(result.Rejected, RejectedAlias{a, fmt.Sprintf("already an alias of %q", deptList[i].DepartmentName)})
+			}
+			continue
+		}
+
+		d := &deptList[target]
+		// A lone "" only keeps the SubList key in the file; the alias takes its place
+		if len(d.SubList) == 1 && strings.TrimSpace(d.SubList[0]) == "" {
+			d.SubList = nil
+		}
+		d.SubList = append(d.SubList, a.Raw)
+		owner[strings.ToUpper(a.Raw)] = target
+		result.Added = append(result.Added, a)
+	}
+
+	if len(result.Added) == 0 {
+		return result, nil
+	}
+	out, err := yaml.Marshal(&deptList)
+	if err != nil {
+		return result, err
+	}
+	backup := fmt.Sprintf("%s.%s.bak", yamlPath, time.Now().Format("20060102-150405"))
+	if err := ioutil.WriteFile(backup, data, 0644); err != nil {
+		return result, fmt.Errorf("failed to write backup: %w", err)
+	}
+	result.Backup = backup
+	log.Printf("[INFO] Backup of %s written to %s", yamlPath, backup)
+	if err := ioutil.WriteFile(yamlPath, out, 0644); err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApprovalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "departments.yaml")
+	if err := os.WriteFile(yamlPath, []byte("- DepartmentName: FINANCE\n  SubList:\n  - Finance\n- DepartmentName: DIGI\n  SubList:\n  - \"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	users := []User{
+		{CN: "Alice", Department: "Keuangan"},
+		{CN: "Bob", Department: "Digital Team"},
+	}
+	matcher, err := NewMatcher(MatcherOptions{Strategy: MatchExact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersOut := filepath.Join(dir, "users.csv")
+	reportOut := filepath.Join(dir, "report.csv")
+	if err := ValidateAndAssignDepartment(users, yamlPath, usersOut, reportOut, matcher, 1, OUFallback); err != nil {
+		t.Fatal(err)
+	}
+
+	// Approve Alice's row after correcting the prediction, as a reviewer would
+	records := readCSV(t, reportOut)
+	col := make(map[string]int)
+	for i, h := range records[0] {
+		col[h] = i
+	}
+	if _, ok := col["Approve"]; !ok || len(records) != 3 {
+		t.Fatalf("report = %v, want two rows and an Approve column", records)
+	}
+	for _, rec := range records[1:] {
+		if rec[col["CN"]] == "Alice" {
+			rec[col["Predicted-Valid-Department"]] = "FINANCE"
+			rec[col["Approve"]] = "y"
+		}
+	}
+	f, err := os.Create(reportOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := csv.NewWriter(f)
+	w.WriteAll(records)
+	f.Close()
+
+	aliases, err := LoadApprovedAliases(reportOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0] != (ApprovedAlias{Raw: "Keuangan", Department: "FINANCE"}) {
+		t.Fatalf("aliases = %+v, want Keuangan -> FINANCE", aliases)
+	}
+	result, err := ApproveDepartmentAliases(yamlPath, aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 1 || result.Backup == "" {
+		t.Errorf("result = %+v, want one alias added and a backup", result)
+	}
+
+	// Alice is now assigned, Bob is still reported
+	if err := ValidateAndAssignDepartment(users, yamlPath, usersOut, reportOut, matcher, 1, OUFallback); err != nil {
+		t.Fatal(err)
+	}
+	if assigned := readCSV(t, usersOut); len(assigned) != 2 || assigned[1][0] != "Alice" || assigned[1][4] != "FINANCE" {
+		t.Errorf("users = %v, want Alice in FINANCE", assigned)
+	}
+	if report := readCSV(t, reportOut); len(report) != 2 || report[1][0] != "Bob" {
+		t.Errorf("report = %v, want only Bob", report)
+	}
+}
+
+func TestLoadApprovedAliasesUnedited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	content := "CN,Email,SAMAccountName,Department,Predicted-Valid-Department,Confidence-Score,Source,Approve\n" +
+		"Alice,,alice,Keuangan,FINANCE,40.00%,,\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := LoadApprovedAliases(path)
+	if err != nil || len(aliases) != 0 {
+		t.Errorf("LoadApprovedAliases = %v, %v, want no aliases and no error", aliases, err)
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// How the OUs of the department list are used, selected with Matching.OUMode (MATCH_OU_MODE)
+const (
+	// OUFallback assigns the department of the user's OU only when the department
+	// attribute is empty or matches nothing
+	OUFallback = "fallback"
+	// OUOverride assigns the department of the user's OU before anything else
+	OUOverride = "override"
+)
+
+type ouMapping struct {
+	dept string
+	ou   string
+	rdns []*ldap.RelativeDN
+}
+
+func compileOUs(deptList DepartmentYAMLList) ([]ouMapping, error) {
+	var mappings []ouMapping
+	for _, d := range deptList {
+		for _, ou := range d.OUs {
+			dn, err := ldap.ParseDN(ou)
+			if err != nil || len(dn.RDNs) == 0 {
+				return nil, fmt.Errorf("department %s: invalid OU %q", d.DepartmentName, ou)
+			}
+			mappings = append(mappings, ouMapping{dept: d.DepartmentName, ou: ou, rdns: dn.RDNs})
+		}
+	}
+	return mappings, nil
+}
+
+// MatchOU returns the department whose OU contains the user's entry. An OU like
+// "OU=Production" matches anywhere in the path, "OU=Production,DC=corp,DC=local"
+// only that subtree; the OU deepest in the tree wins.
+func (s *RuleSet) MatchOU(u User) (dept, matchedBy string, ok bool) {
+	if len(s.ous) == 0 || u.DN == "" {
+		return "", "", false
+	}
+	dn, err := ldap.ParseDN(u.DN)
+	if err != nil || len(dn.RDNs) < 2 {
+		return "", "", false
+	}
+	// The first component is the entry itself
+	path := dn.RDNs[1:]
+	best, bestDepth := -1, len(path)
+	for i, m := range s.ous {
+		// depth is the number of components between the entry and the OU
+		if depth := indexRDNs(path, m.rdns); depth >= 0 && depth < bestDepth {
+			best, bestDepth = i, depth
+		}
+	}
+	if best < 0 {
+		return "", "", false
+	}
+	return s.ous[best].dept, "ou " + s.ous[best].ou, true
+}
+
+// indexRDNs returns where sub first appears as consecutive components of path, or -1
+func indexRDNs(path, sub []*ldap.RelativeDN) int {
+	for i := 0; i+len(sub) <= len(path); i++ {
+		match := true
+		for j := range sub {
+			if !path[i+j].EqualFold(sub[j]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidOUMode tells whether mode is one of OUFallback and OUOverride
+func ValidOUMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case OUFallback, OUOverride:
+		return true
+	}
+	return false
+}
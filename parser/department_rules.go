@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// RuleOU is the rule key matched against the OU path of the user's DN
+// ("OU=SMT,OU=Production,DC=corp,DC=local"); every other key is an LDAP attribute
+const RuleOU = "OU"
+
+// DepartmentRule maps LDAP attributes to the regex their value must match. A
+// rule matches when all of its attributes match.
+type DepartmentRule map[string]string
+
+// RuleSet are the compiled Patterns and Rules of a department list. They are
+// evaluated in the order of the list, before fuzzy matching; the first
+// department with a matching pattern or rule wins.
+type RuleSet struct {
+	departments []departmentRules
+	ous         []ouMapping
+}
+
+type departmentRules struct {
+	name     string
+	patterns []*regexp.Regexp
+	rules    []compiledRule
+}
+
+type compiledRule struct {
+	desc  string
+	conds []ruleCondition
+}
+
+type ruleCondition struct {
+	attr string
+	re   *regexp.Regexp
+}
+
+// CompileRules compiles the Patterns, Rules and OUs of every department of the list
+func CompileRules(deptList DepartmentYAMLList) (*RuleSet, error) {
+	ous, err := compileOUs(deptList)
+	if err != nil {
+		return nil, err
+	}
+	set := &RuleSet{ous: ous}
+	for _, d := range deptList {
+		dr := departmentRules{name: d.DepartmentName}
+		for _, p := range d.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("department %s: invalid pattern: %w", d.DepartmentName, err)
+			}
+			dr.patterns = append(dr.patterns, re)
+		}
+		for i, rule := range d.Rules {
+			if len(rule) == 0 {
+				return nil, fmt.Errorf("department %s: rule %d is empty", d.DepartmentName, i+1)
+			}
+			attrs := make([]string, 0, len(rule))
+			for attr := range rule {
+				attrs = append(attrs, attr)
+			}
+			sort.Strings(attrs)
+			cr := compiledRule{}
+			var desc []string
+			for _, attr := range attrs {
+				re, err := regexp.Compile(rule[attr])
+				if err != nil {
+					return nil, fmt.Errorf("department %s: rule %d, %s: %w", d.DepartmentName, i+1, attr, err)
+				}
+				cr.conds = append(cr.conds, ruleCondition{attr, re})
+				desc = append(desc, attr+"="+rule[attr])
+			}
+			cr.desc = strings.Join(desc, ", ")
+			dr.rules = append(dr.rules, cr)
+		}
+		if len(dr.patterns) > 0 || len(dr.rules) > 0 {
+			set.departments = append(set.departments, dr)
+		}
+	}
+	return set, nil
+}
+
+// Attributes returns the LDAP attributes the rules need besides the DN
+func (s *RuleSet) Attributes() []string {
+	seen := make(map[string]bool)
+	var attrs []string
+	for _, d := range s.departments {
+		for _, r := range d.rules {
+			for _, c := range r.conds {
+				if !strings.EqualFold(c.attr, RuleOU) && !seen[c.attr] {
+					seen[c.attr] = true
+					attrs = append(attrs, c.attr)
+				}
+			}
+		}
+	}
+	return attrs
+}
+
+// Match returns the department of the first matching pattern or rule, and a
+// description of what matched for the users CSV
+func (s *RuleSet) Match(u User) (dept, matchedBy string, ok bool) {
+	for _, d := range s.departments {
+		for _, re := range d.patterns {
+			if re.MatchString(u.Department) {
+				return d.name, "pattern " + re.String(), true
+			}
+		}
+		for _, r := range d.rules {
+			if r.matches(u) {
+				return d.name, "rule " + r.desc, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func (r compiledRule) matches(u User) bool {
+	for _, c := range r.conds {
+		if !c.re.MatchString(ruleValue(u, c.attr)) {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleValue(u User, attr string) string {
+	if strings.EqualFold(attr, RuleOU) {
+		return OUPath(u.DN)
+	}
+	if v, ok := u.Attributes[attr]; ok {
+		return v
+	}
+	for name, v := range u.Attributes {
+		if strings.EqualFold(name, attr) {
+			return v
+		}
+	}
+	return ""
+}
+
+// OUPath returns the DN without its first component, i.e. the OU path the entry
+// sits in, as "OU=SMT,OU=Production,DC=corp,DC=local". Escaped commas in the
+// entry's own name ("CN=Doe\, John") do not cut the path.
+func OUPath(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 2 {
+		return ""
+	}
+	rdns := make([]string, 0, len(parsed.RDNs)-1)
+	for _, rdn := range parsed.RDNs[1:] {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, a := range rdn.Attributes {
+			attrs = append(attrs, strings.ToUpper(a.Type)+"="+escapeDNValue(a.Value))
+		}
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
+
+// escapeDNValue escapes an attribute value as RFC 4514 requires
+func escapeDNValue(v string) string {
+	var b strings.Builder
+	for i, r := range v {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(v)-1 && r == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package parser
+
+import "testing"
+
+func TestOUPath(t *testing.T) {
+	tests := []struct {
+		dn       string
+		expected string
+	}{
+		{"CN=Alice,OU=Sales,DC=corp,DC=local", "OU=Sales,DC=corp,DC=local"},
+		{`CN=Doe\, John,OU=Sales,DC=corp,DC=local`, "OU=Sales,DC=corp,DC=local"},
+		{"cn=Alice, ou=Sales ,dc=corp", "OU=Sales,DC=corp"},
+		{`CN=Alice,OU=R\2CD,DC=corp`, `OU=R\,D,DC=corp`},
+		{"CN=Alice", ""},
+		{"not a dn", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := OUPath(tt.dn); got != tt.expected {
+			t.Errorf("OUPath(%q) = %q, want %q", tt.dn, got, tt.expected)
+		}
+	}
+}
+
+func TestRuleSetMatchOURule(t *testing.T) {
+	set, err := CompileRules(DepartmentYAMLList{
+		{DepartmentName: "SALES", Rules: []DepartmentRule{{RuleOU: "^OU=Sales,"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := User{DN: `CN=Doe\, John,OU=Sales,DC=corp,DC=local`}
+	if dept, _, ok := set.Match(u); !ok || dept != "SALES" {
+		t.Errorf("Match(%s) = %q, %v, want SALES", u.DN, dept, ok)
+	}
+}
@@ -7,26 +7,53 @@ import (
 	"os"
 	"strings"
 
-	"github.com/xrash/smetrics"
 	"gopkg.in/yaml.v2"
 )
 
 type DepartmentYAML struct {
 	DepartmentName string   `yaml:"DepartmentName"`
 	SubList        []string `yaml:"SubList"`
+	// Patterns are regexes on the raw department, checked before fuzzy matching
+	Patterns []string `yaml:"Patterns,omitempty"`
+	// Rules match on other LDAP attributes or the OU path, checked before fuzzy matching
+	Rules []DepartmentRule `yaml:"Rules,omitempty"`
+	// OUs assign the department from the user's OU, see OUFallback and OUOverride
+	OUs []string `yaml:"OUs,omitempty"`
+	// Organization is the iTop Organization of the Team when the hierarchy is
+	// mirrored, and Parent the parent Organization of that one
+	Organization string `yaml:"Organization,omitempty"`
+	Parent       string `yaml:"Parent,omitempty"`
+	// OrganizationID and TeamID are written by the team sync; they are kept so the
+	// list can be rewritten
+	OrganizationID string `yaml:"OrganizationID,omitempty"`
+	TeamID         string `yaml:"TeamID,omitempty"`
 }
 
 type DepartmentYAMLList []DepartmentYAML
 
-// ValidateAndAssignDepartment validates and assigns the best DepartmentName for each user
-func ValidateAndAssignDepartment(users []User, yamlPath, usersOut, reportOut string, threshold float64) error {
-	// Read YAML
+// LoadDepartmentList reads the department list YAML
+func LoadDepartmentList(yamlPath string) (DepartmentYAMLList, error) {
 	data, err := ioutil.ReadFile(yamlPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var deptList DepartmentYAMLList
 	if err := yaml.Unmarshal(data, &deptList); err != nil {
+		return nil, err
+	}
+	return deptList, nil
+}
+
+// ValidateAndAssignDepartment validates and assigns the best DepartmentName for each user.
+// The Patterns and Rules of the list are tried first, then the matcher. The OUs
+// of the list are tried before everything else or after, depending on ouMode.
+func ValidateAndAssignDepartment(users []User, yamlPath, usersOut, reportOut string, matcher Matcher, threshold float64, ouMode string) error {
+	deptList, err := LoadDepartmentList(yamlPath)
+	if err != nil {
+		return err
+	}
+	rules, err := CompileRules(deptList)
+	if err != nil {
 		return err
 	}
 
@@ -37,7 +64,7 @@ func ValidateAndAssignDepartment(users []User, yamlPath, usersOut, reportOut str
 	defer usersFile.Close()
 	usersWriter := csv.NewWriter(usersFile)
 	defer usersWriter.Flush()
-	usersWriter.Write([]string{"CN", "Email", "SAMAccountName", "Department", "Valid-Department"})
+	usersWriter.Write([]string{"CN", "Email", "SAMAccountName", "Department", "Valid-Department", "Source", "Org-ID", "Account-Status", "First-Name", "Last-Name", "Phone", "Matched-By"})
 
 	reportFile, err := os.Create(reportOut)
 	if err != nil {
@@ -46,35 +73,35 @@ func ValidateAndAssignDepartment(users []User, yamlPath, usersOut, reportOut str
 	defer reportFile.Close()
 	reportWriter := csv.NewWriter(reportFile)
 	defer reportWriter.Flush()
-	reportWriter.Write([]string{"CN", "Email", "SAMAccountName", "Department", "Predicted-Valid-Department", "Confidence-Score"})
+	reportWriter.Write([]string{"CN", "Email", "SAMAccountName", "Department", "Predicted-Valid-Department", "Confidence-Score", "Source", "Approve"})
 
 	for _, u := range users {
-		bestDept := ""
-		bestScore := 0.0
-		for _, d := range deptList {
-			// Compare to DepartmentName
-			score := smetrics.JaroWinkler(strings.ToUpper(u.Department), strings.ToUpper(d.DepartmentName), 0.7, 4)
-			if score > bestScore {
-				bestScore = score
-				bestDept = d.DepartmentName
-			}
-			// Compare to SubList
-			for _, sub := range d.SubList {
-				if strings.TrimSpace(sub) == "" {
-					continue
-				}
-				subScore := smetrics.JaroWinkler(strings.ToUpper(u.Department), strings.ToUpper(sub), 0.7, 4)
-				if subScore > bestScore {
-					bestScore = subScore
-					bestDept = d.DepartmentName
-				}
+		assign := func(dept, matchedBy string) {
+			usersWriter.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department, dept, u.Source, u.OrgID, string(u.Status), u.FirstName, u.LastName, u.Phone, matchedBy})
+		}
+		if strings.EqualFold(ouMode, OUOverride) {
+			if dept, matchedBy, ok := rules.MatchOU(u); ok {
+				assign(dept, matchedBy)
+				continue
 			}
 		}
+		if dept, matchedBy, ok := rules.Match(u); ok {
+			assign(dept, matchedBy)
+			continue
+		}
+		bestDept, bestScore := "", 0.0
+		if strings.TrimSpace(u.Department) != "" {
+			bestDept, bestScore = BestDepartment(matcher, u.Department, deptList)
+		}
 		if bestScore >= threshold {
-			usersWriter.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department, bestDept})
+			assign(bestDept, fmt.Sprintf("%s %.2f%%", matcher.Name(), bestScore*100))
+		} else if dept, matchedBy, ok := rules.MatchOU(u); ok {
+			// Fallback; in override mode this did not match above either
+			assign(dept, matchedBy)
 		} else {
-			// Report: show best guess and confidence
-			reportWriter.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department, bestDept, fmt.Sprintf("%.2f%%", bestScore*100)})
+			// Report: show best guess and confidence; Approve is filled in by hand
+			// for approve-departments
+			reportWriter.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department, bestDept, fmt.Sprintf("%.2f%%", bestScore*100), u.Source, ""})
 		}
 	}
 	return nil
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+)
+
+// Duplicate describes a user dropped because an earlier user has the same
+// sAMAccountName or mail
+type Duplicate struct {
+	KeyType   string // "sAMAccountName" or "mail"
+	Key       string
+	Kept      User
+	Duplicate User
+}
+
+// MergeUsers merges the users of several sources in order. A user whose
+// sAMAccountName or mail was already seen (case-insensitive) is left out and
+// reported as a duplicate of the first one. Active accounts are considered before
+// disabled and expired ones, so a person with a disabled account in one source and
+// an active one in another keeps the active one.
+func MergeUsers(lists ...[]User) ([]User, []Duplicate) {
+	bySAM := make(map[string]User)
+	byMail := make(map[string]User)
+	kept := make([][]bool, len(lists))
+	var dups []Duplicate
+	consider := func(active bool) {
+		for li, list := range lists {
+			if kept[li] == nil {
+				kept[li] = make([]bool, len(list))
+			}
+			for ui, u := range list {
+				if isActive(u) != active {
+					continue
+				}
+				sam := strings.ToLower(strings.TrimSpace(u.SAMAccountName))
+				mail := strings.ToLower(strings.TrimSpace(u.Email))
+				if first, ok := bySAM[sam]; ok && sam != "" {
+					dups = append(dups, Duplicate{KeyType: "sAMAccountName", Key: u.SAMAccountName, Kept: first, Duplicate: u})
+					continue
+				}
+				if first, ok := byMail[mail]; ok && mail != "" {
+					dups = append(dups, Duplicate{KeyType: "mail", Key: u.Email, Kept: first, Duplicate: u})
+					continue
+				}
+				if sam != "" {
+					bySAM[sam] = u
+				}
+				if mail != "" {
+					byMail[mail] = u
+				}
+				kept[li][ui] = true
+			}
+		}
+	}
+	consider(true)
+	consider(false)
+
+	// The merged list keeps the source order
+	var merged []User
+	for li, list := range lists {
+		for ui, u := range list {
+			if kept[li][ui] {
+				merged = append(merged, u)
+			}
+		}
+	}
+	return merged, dups
+}
+
+// SaveDuplicatesToCSV writes the duplicates found by MergeUsers
+func SaveDuplicatesToCSV(dups []Duplicate, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"Key-Type", "Key", "Kept-Source", "Kept-CN", "Kept-SAMAccountName", "Duplicate-Source", "Duplicate-CN", "Duplicate-SAMAccountName"}); err != nil {
+		return err
+	}
+	for _, d := range dups {
+		if err := writer.Write([]string{d.KeyType, d.Key, d.Kept.Source, d.Kept.CN, d.Kept.SAMAccountName, d.Duplicate.Source, d.Duplicate.CN, d.Duplicate.SAMAccountName}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
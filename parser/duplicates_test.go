@@ -0,0 +1,39 @@
+package parser
+
+import "testing"
+
+func TestMergeUsers(t *testing.T) {
+	first := []User{
+		{CN: "Alice", SAMAccountName: "alice", Email: "alice@example.com", Source: "one"},
+		{CN: "Bob", SAMAccountName: "bob", Email: "bob@example.com", Source: "one"},
+	}
+	second := []User{
+		{CN: "Alice", SAMAccountName: "ALICE", Source: "two"},
+		{CN: "Bob 2", SAMAccountName: "bob2", Email: "Bob@Example.com", Source: "two"},
+		{CN: "Carol", SAMAccountName: "carol", Source: "two"},
+	}
+	merged, dups := MergeUsers(first, second)
+	if len(merged) != 3 || merged[0].CN != "Alice" || merged[1].CN != "Bob" || merged[2].CN != "Carol" {
+		t.Errorf("merged = %+v, want Alice, Bob and Carol of the first source", merged)
+	}
+	if len(dups) != 2 || dups[0].KeyType != "sAMAccountName" || dups[1].KeyType != "mail" {
+		t.Errorf("dups = %+v, want one by sAMAccountName and one by mail", dups)
+	}
+}
+
+func TestMergeUsersPrefersActive(t *testing.T) {
+	// The person moved forests: disabled in the first source, active in the second
+	first := []User{{CN: "Dave", SAMAccountName: "dave", Source: "old", Status: AccountDisabled}}
+	second := []User{{CN: "Dave", SAMAccountName: "dave", Source: "new", Status: AccountActive}}
+	merged, dups := MergeUsers(first, second)
+	if len(merged) != 1 || merged[0].Source != "new" {
+		t.Fatalf("merged = %+v, want the active account of source new", merged)
+	}
+	if len(dups) != 1 || dups[0].Duplicate.Source != "old" {
+		t.Errorf("dups = %+v, want the disabled account reported", dups)
+	}
+	active, _ := SplitActiveUsers(merged)
+	if len(active) != 1 {
+		t.Errorf("the user disappeared from the sync")
+	}
+}
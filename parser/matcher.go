@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/xrash/smetrics"
+)
+
+// Matcher scores how well a raw LDAP department matches a department of the
+// list, from 0 (no match) to 1 (certain)
+type Matcher interface {
+	Name() string
+	Score(raw string, dept DepartmentYAML) float64
+}
+
+// Matching strategies, selected with Matching.Strategy (MATCH_STRATEGY)
+const (
+	MatchJaroWinkler = "jarowinkler"
+	MatchExact       = "exact"
+	MatchTokenSet    = "tokenset"
+	MatchLevenshtein = "levenshtein"
+	MatchWeighted    = "weighted"
+)
+
+// MatchStrategies are the strategies that can be used alone or in a weighted combination
+var MatchStrategies = []string{MatchJaroWinkler, MatchExact, MatchTokenSet, MatchLevenshtein}
+
+// MatcherOptions describes the matcher to build
+type MatcherOptions struct {
+	Strategy string
+	// Weights of the strategies combined by MatchWeighted, e.g. tokenset=0.6, jarowinkler=0.4
+	Weights map[string]float64
+}
+
+// NewMatcher builds the matcher of opts; an empty strategy means Jaro-Winkler
+func NewMatcher(opts MatcherOptions) (Matcher, error) {
+	strategy := strings.ToLower(opts.Strategy)
+	if strategy == "" {
+		strategy = MatchJaroWinkler
+	}
+	if strategy != MatchWeighted {
+		return newBasicMatcher(strategy)
+	}
+
+	if len(opts.Weights) == 0 {
+		return nil, fmt.Errorf("strategy %s needs weights", MatchWeighted)
+	}
+	names := make([]string, 0, len(opts.Weights))
+	for name := range opts.Weights {
+		names = append(names, name)
+	}
+	// Sorted so the combination does not depend on map order
+	sort.Strings(names)
+	w := &weightedMatcher{}
+	for _, name := range names {
+		weight := opts.Weights[name]
+		if weight < 0 {
+			return nil, fmt.Errorf("weight of %s must not be negative", name)
+		}
+		if weight == 0 {
+			continue
+		}
+		m, err := newBasicMatcher(strings.ToLower(name))
+		if err != nil {
+			return nil, err
+		}
+		w.parts = append(w.parts, m)
+		w.weights = append(w.weights, weight)
+		w.total += weight
+	}
+	if w.total == 0 {
+		return nil, fmt.Errorf("strategy %s needs at least one positive weight", MatchWeighted)
+	}
+	return w, nil
+}
+
+func newBasicMatcher(strategy string) (Matcher, error) {
+	switch strategy {
+	case MatchJaroWinkler:
+		return similarityMatcher{strategy, jaroWinklerSimilarity}, nil
+	case MatchExact:
+		return similarityMatcher{strategy, exactSimilarity}, nil
+	case MatchTokenSet:
+		return similarityMatcher{strategy, tokenSetSimilarity}, nil
+	case MatchLevenshtein:
+		return similarityMatcher{strategy, levenshteinSimilarity}, nil
+	case "regex":
+		return nil, fmt.Errorf("strategy regex is replaced by the Patterns of the department list, which are checked before any strategy")
+	}
+	return nil, fmt.Errorf("unknown matching strategy %q, expected one of %s or %s",
+		strategy, strings.Join(MatchStrategies, ", "), MatchWeighted)
+}
+
+// BestDepartment returns the department of the list that m scores highest for raw.
+// On a tie the first one of the list wins.
+func BestDepartment(m Matcher, raw string, deptList DepartmentYAMLList) (string, float64) {
+	bestDept := ""
+	bestScore := 0.0
+	for _, d := range deptList {
+		if score := m.Score(raw, d); score > bestScore {
+			bestScore = score
+			bestDept = d.DepartmentName
+		}
+	}
+	return bestDept, bestScore
+}
+
+// similarityMatcher compares raw with the DepartmentName and every SubList entry
+// and keeps the best score
+type similarityMatcher struct {
+	name string
+	fn   func(a, b string) float64
+}
+
+func (m similarityMatcher) Name() string { return m.name }
+
+func (m similarityMatcher) Score(raw string, dept DepartmentYAML) float64 {
+	best := m.fn(raw, dept.DepartmentName)
+	for _, sub := range dept.SubList {
+		if strings.TrimSpace(sub) == "" {
+			continue
+		}
+		if score := m.fn(raw, sub); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+func jaroWinklerSimilarity(a, b string) float64 {
+	return smetrics.JaroWinkler(strings.ToUpper(a), strings.ToUpper(b), 0.7, 4)
+}
+
+// tokens upper-cases s and splits it on everything that is not a letter or digit
+func tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalize ignores case, punctuation and spacing: "Finance - IA" == "FINANCE IA"
+func normalize(s string) string {
+	return strings.Join(tokens(s), " ")
+}
+
+func exactSimilarity(a, b string) float64 {
+	na := normalize(a)
+	if na != "" && na == normalize(b) {
+		return 1
+	}
+	return 0
+}
+
+func levenshteinSimilarity(a, b string) float64 {
+	na, nb := normalize(a), normalize(b)
+	longest := len(na)
+	if len(nb) > longest {
+		longest = len(nb)
+	}
+	if na == "" || longest == 0 {
+		return 0
+	}
+	return 1 - float64(smetrics.WagnerFischer(na, nb, 1, 1, 1))/float64(longest)
+}
+
+// tokenSetSimilarity is the share of tokens of both strings that have a
+// counterpart in the other one, regardless of order. A token also counts when it
+// is the acronym of consecutive tokens of the other string, so "IA Finance"
+// fully matches "FINANCE INTERNAL AUDITOR".
+func tokenSetSimilarity(a, b string) float64 {
+	ta, tb := tokens(a), tokens(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	doneA, doneB := make([]bool, len(ta)), make([]bool, len(tb))
+	for i, x := range ta {
+		for j, y := range tb {
+			if !doneB[j] && x == y {
+				doneA[i], doneB[j] = true, true
+				break
+			}
+		}
+	}
+	coverAcronyms(ta, doneA, tb, doneB)
+	coverAcronyms(tb, doneB, ta, doneA)
+
+	covered := 0
+	for _, done := range append(doneA, doneB...) {
+		if done {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(ta)+len(tb))
+}
+
+// coverAcronyms marks the unmatched tokens of short that spell the initials of
+// unmatched consecutive tokens of long
+func coverAcronyms(short []string, shortDone []bool, long []string, longDone []bool) {
+	for i, tok := range short {
+		if shortDone[i] || len(tok) < 2 {
+			continue
+		}
+		for j := 0; j+len(tok) <= len(long); j++ {
+			match := true
+			for k := 0; k < len(tok); k++ {
+				if longDone[j+k] || long[j+k][0] != tok[k] {
+					match = false
+					break
+				}
+			}
+			if match {
+				shortDone[i] = true
+				for k := 0; k < len(tok); k++ {
+					longDone[j+k] = true
+				}
+				break
+			}
+		}
+	}
+}
+
+// weightedMatcher is the weighted average of several strategies
+type weightedMatcher struct {
+	parts   []Matcher
+	weights []float64
+	total   float64
+}
+
+func (m *weightedMatcher) Name() string { return MatchWeighted }
+
+func (m *weightedMatcher) Score(raw string, dept DepartmentYAML) float64 {
+	sum := 0.0
+	for i, part := range m.parts {
+		sum += m.weights[i] * part.Score(raw, dept)
+	}
+	return sum / m.total
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MatcherSummary counts the users a matcher would assign a department to
+type MatcherSummary struct {
+	Name    string
+	Matched int
+	Total   int
+}
+
+// CompareMatchers writes, for every distinct raw department of users, the
+// department and score each matcher would pick, so strategies can be compared on
+// the current directory before switching
+func CompareMatchers(users []User, yamlPath string, matchers []Matcher, threshold float64, out string) ([]MatcherSummary, error) {
+	deptList, err := LoadDepartmentList(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	count := make(map[string]int)
+	for _, u := range users {
+		count[u.Department]++
+	}
+	raws := make([]string, 0, len(count))
+	for raw := range count {
+		raws = append(raws, raw)
+	}
+	// Most common departments first
+	sort.Slice(raws, func(i, j int) bool {
+		if count[raws[i]] != count[raws[j]] {
+			return count[raws[i]] > count[raws[j]]
+		}
+		return raws[i] < raws[j]
+	})
+
+	file, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	defer w.Flush()
+
+	header := []string{"Department", "Users"}
+	summaries := make([]MatcherSummary, len(matchers))
+	for i, m := range matchers {
+		header = append(header, m.Name()+"-Department", m.Name()+"-Score")
+		summaries[i] = MatcherSummary{Name: m.Name(), Total: len(users)}
+	}
+	w.Write(header)
+
+	for _, raw := range raws {
+		row := []string{raw, fmt.Sprint(count[raw])}
+		for i, m := range matchers {
+			dept, score := BestDepartment(m, raw, deptList)
+			if score >= threshold {
+				summaries[i].Matched += count[raw]
+			}
+			row = append(row, dept, fmt.Sprintf("%.2f%%", score*100))
+		}
+		w.Write(row)
+	}
+	return summaries, nil
+}
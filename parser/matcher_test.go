@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestTokenSetSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"IA Finance", "FINANCE INTERNAL AUDITOR", 1},
+		{"Finance - IA", "ia finance", 1},
+		{"Human Resources", "HR", 1},
+		{"Finance", "Finance Tax", 2.0 / 3},
+		{"Finance", "Marketing", 0},
+		// A single letter is not an acronym
+		{"F", "FINANCE", 0},
+		{"", "FINANCE", 0},
+	}
+	for _, tt := range tests {
+		if got := tokenSetSimilarity(tt.a, tt.b); !near(got, tt.expected) {
+			t.Errorf("tokenSetSimilarity(%q, %q) = %.3f, want %.3f", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestExactSimilarity(t *testing.T) {
+	if got := exactSimilarity("Finance - IA", "FINANCE IA"); got != 1 {
+		t.Errorf("exact ignoring case and punctuation = %v, want 1", got)
+	}
+	if got := exactSimilarity("Finance", "Finance IA"); got != 0 {
+		t.Errorf("exact on different names = %v, want 0", got)
+	}
+	if got := exactSimilarity("--", ""); got != 0 {
+		t.Errorf("exact on empty names = %v, want 0", got)
+	}
+}
+
+func TestLevenshteinSimilarity(t *testing.T) {
+	if got := levenshteinSimilarity("Finanse", "FINANCE"); !near(got, 1-1.0/7) {
+		t.Errorf("one typo in 7 letters = %.3f, want %.3f", got, 1-1.0/7)
+	}
+	if got := levenshteinSimilarity("finance", "FINANCE"); got != 1 {
+		t.Errorf("levenshtein ignoring case = %v, want 1", got)
+	}
+	if got := levenshteinSimilarity("", "FINANCE"); got != 0 {
+		t.Errorf("levenshtein of empty = %v, want 0", got)
+	}
+}
+
+func TestJaroWinklerSimilarity(t *testing.T) {
+	if got := jaroWinklerSimilarity("digi", "DIGI"); got != 1 {
+		t.Errorf("jarowinkler ignoring case = %v, want 1", got)
+	}
+	if close, far := jaroWinklerSimilarity("Finanse", "FINANCE"), jaroWinklerSimilarity("Marketing", "FINANCE"); close <= far {
+		t.Errorf("jarowinkler(Finanse) = %.3f not above jarowinkler(Marketing) = %.3f", close, far)
+	}
+}
+
+var matcherDepartments = DepartmentYAMLList{
+	{DepartmentName: "DIGI", SubList: []string{"Digital", ""}},
+	{DepartmentName: "FINANCE", SubList: []string{"Finance Internal Auditor"}},
+	{DepartmentName: "FINANCE TAX"},
+}
+
+func TestBestDepartment(t *testing.T) {
+	tests := []struct {
+		strategy string
+		raw      string
+		dept     string
+	}{
+		{MatchExact, "digital", "DIGI"},
+		{MatchTokenSet, "IA Finance", "FINANCE"},
+		{MatchTokenSet, "Tax - Finance", "FINANCE TAX"},
+		{MatchLevenshtein, "Finanse", "FINANCE"},
+		{MatchJaroWinkler, "Digitl", "DIGI"},
+		{"", "Digitl", "DIGI"},
+		{MatchExact, "Marketing", ""},
+	}
+	for _, tt := range tests {
+		m, err := NewMatcher(MatcherOptions{Strategy: tt.strategy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dept, _ := BestDepartment(m, tt.raw, matcherDepartments); dept != tt.dept {
+			t.Errorf("%s: BestDepartment(%q) = %q, want %q", tt.strategy, tt.raw, dept, tt.dept)
+		}
+	}
+}
+
+func TestBestDepartmentTie(t *testing.T) {
+	m, _ := NewMatcher(MatcherOptions{Strategy: MatchExact})
+	list := DepartmentYAMLList{{DepartmentName: "IT"}, {DepartmentName: "OPS", SubList: []string{"it"}}}
+	if dept, score := BestDepartment(m, "IT", list); dept != "IT" || score != 1 {
+		t.Errorf("tie = %q (%.2f), want the first department IT", dept, score)
+	}
+}
+
+func TestWeightedMatcher(t *testing.T) {
+	m, err := NewMatcher(MatcherOptions{
+		Strategy: MatchWeighted,
+		Weights:  map[string]float64{"exact": 1, "TokenSet": 3, "levenshtein": 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exact 0, tokenset 1: (0*1 + 1*3) / 4
+	if got := m.Score("IA Finance", matcherDepartments[1]); !near(got, 0.75) {
+		t.Errorf("weighted score = %.3f, want 0.75", got)
+	}
+}
+
+func TestNewMatcherErrors(t *testing.T) {
+	for _, opts := range []MatcherOptions{
+		{Strategy: "soundex"},
+		// Regexes are the Patterns of the department list, not a strategy
+		{Strategy: "regex"},
+		{Strategy: MatchWeighted},
+		{Strategy: MatchWeighted, Weights: map[string]float64{"exact": 0}},
+		{Strategy: MatchWeighted, Weights: map[string]float64{"exact": -1}},
+		{Strategy: MatchWeighted, Weights: map[string]float64{"soundex": 1}},
+		{Strategy: MatchWeighted, Weights: map[string]float64{MatchWeighted: 1}},
+	} {
+		if _, err := NewMatcher(opts); err == nil {
+			t.Errorf("NewMatcher(%+v) succeeded, want an error", opts)
+		}
+	}
+}
@@ -2,19 +2,36 @@ package parser
 
 import (
 	"encoding/csv"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 )
 
 type User struct {
+	DN             string
 	CN             string
 	Email          string
 	SAMAccountName string
 	Department     string
+	FirstName      string
+	LastName       string
+	Phone          string
+	ManagerDN      string
+	Source         string // name of the LDAP source the user was read from
+	OrgID          string // iTop organization of that source, if any
+	Status         AccountStatus
+	Attributes     map[string]string // extra LDAP attributes requested through AttributeMap.Extra
 }
 
-// SaveUsersToCSV saves the list of users to a CSV file with CN, Email, SAMAccountName, Department fields
+// userCSVHeader are the columns written by SaveUsersToCSV and read by LoadUsersFromCSV
+var userCSVHeader = []string{"CN", "Email", "SAMAccountName", "Department", "Source", "Org-ID", "Account-Status", "First-Name", "Last-Name", "Phone", "DN", "Manager-DN"}
+
+// SaveUsersToCSV saves the list of users to a CSV file, one column per User field
+// followed by one per extra attribute
 func SaveUsersToCSV(users []User, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -25,27 +42,140 @@ func SaveUsersToCSV(users []User, filename string) error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
+	extra := extraAttributes(users)
 	// Write header
-	if err := writer.Write([]string{"CN", "Email", "SAMAccountName", "Department"}); err != nil {
+	if err := writer.Write(append(append([]string{}, userCSVHeader...), extra...)); err != nil {
 		return err
 	}
 
 	for _, u := range users {
-		if err := writer.Write([]string{u.CN, u.Email, u.SAMAccountName, u.Department}); err != nil {
+		row := []string{u.CN, u.Email, u.SAMAccountName, u.Department, u.Source, u.OrgID, string(u.Status), u.FirstName, u.LastName, u.Phone, u.DN, u.ManagerDN}
+		for _, attr := range extra {
+			row = append(row, u.Attributes[attr])
+		}
+		if err := writer.Write(row); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// extraAttributes returns the sorted names of the extra attributes of users
+func extraAttributes(users []User) []string {
+	seen := make(map[string]bool)
+	var attrs []string
+	for _, u := range users {
+		for attr := range u.Attributes {
+			if !seen[attr] {
+				seen[attr] = true
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	sort.Strings(attrs)
+	return attrs
+}
+
+// LoadUsersFromCSV reads users written by SaveUsersToCSV, or a hand-made CSV with at
+// least the CN and Department columns. Columns are matched by header name, so they
+// may come in any order; missing ones are left empty and unknown ones are read as
+// extra attributes.
+func LoadUsersFromCSV(filename string) ([]User, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s is empty", filename)
+	}
+	col := make(map[string]int)
+	for i, h := range records[0] {
+		col[strings.TrimSpace(h)] = i
+	}
+	for _, required := range []string{"CN", "Department"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("%s has no %s column", filename, required)
+		}
+	}
+	known := make(map[string]bool, len(userCSVHeader))
+	for _, h := range userCSVHeader {
+		known[h] = true
+	}
+	users := make([]User, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
+		var extra map[string]string
+		for name := range col {
+			if !known[name] {
+				if extra == nil {
+					extra = make(map[string]string)
+				}
+				extra[name] = field(name)
+			}
+		}
+		status := AccountStatus(field("Account-Status"))
+		if status == "" {
+			status = AccountActive
+		}
+		users = append(users, User{
+			DN:             field("DN"),
+			CN:             field("CN"),
+			Email:          field("Email"),
+			SAMAccountName: field("SAMAccountName"),
+			Department:     field("Department"),
+			FirstName:      field("First-Name"),
+			LastName:       field("Last-Name"),
+			Phone:          field("Phone"),
+			ManagerDN:      field("Manager-DN"),
+			Source:         field("Source"),
+			OrgID:          field("Org-ID"),
+			Status:         status,
+			Attributes:     extra,
+		})
+	}
+	return users, nil
+}
+
+// ParseUsers converts LDAP entries to users using the default attribute mapping
 func ParseUsers(entries []*ldap.Entry) []User {
+	return ParseUsersWithMap(entries, DefaultAttributeMap())
+}
+
+// ParseUsersWithMap converts LDAP entries to users reading the attributes named in m
+func ParseUsersWithMap(entries []*ldap.Entry, m AttributeMap) []User {
+	now := time.Now()
 	users := make([]User, 0, len(entries))
 	for _, entry := range entries {
+		var extra map[string]string
+		if len(m.Extra) > 0 {
+			extra = make(map[string]string, len(m.Extra))
+			for _, attr := range m.Extra {
+				extra[attr] = entry.GetAttributeValue(attr)
+			}
+		}
 		users = append(users, User{
-			CN:             entry.GetAttributeValue("cn"),
-			Email:          entry.GetAttributeValue("mail"),
-			SAMAccountName: entry.GetAttributeValue("sAMAccountName"),
-			Department:     entry.GetAttributeValue("department"),
+			DN:             entry.DN,
+			CN:             entry.GetAttributeValue(m.CN),
+			Email:          entry.GetAttributeValue(m.Email),
+			SAMAccountName: entry.GetAttributeValue(m.SAMAccountName),
+			Department:     entry.GetAttributeValue(m.Department),
+			FirstName:      entry.GetAttributeValue(m.FirstName),
+			LastName:       entry.GetAttributeValue(m.LastName),
+			Phone:          entry.GetAttributeValue(m.Phone),
+			ManagerDN:      entry.GetAttributeValue(m.Manager),
+			Status:         ClassifyAccount(entry.GetAttributeValue(m.UserAccountControl), entry.GetAttributeValue(m.AccountExpires), now),
+			Attributes:     extra,
 		})
 	}
 	return users
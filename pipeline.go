@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"ldap-itop/config"
+	"ldap-itop/helper"
+	"ldap-itop/itopclient"
+	"ldap-itop/ldapclient"
+	"ldap-itop/metrics"
+	"ldap-itop/parser"
+	"ldap-itop/synchronizer"
+)
+
+// runOptions are the per-invocation switches of runSync
+type runOptions struct {
+	DryRun bool
+	// FullSync ignores the incremental sync state and reads every user
+	FullSync bool
+}
+
+// runSync runs the whole pipeline once: LDAP export, department validation, team and
+// user sync to iTop and the error email. The context is checked between stages so a
+// shutdown request stops the run at the next stage boundary.
+func runSync(ctx context.Context, cfg *config.Config, opts runOptions) error {
+	export, err := exportUsers(cfg, opts.FullSync)
+	if err != nil {
+		return err
+	}
+	users := export.Users
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Validate and assign department, write CSV reports
+	yamlPath := cfg.Paths.DepartmentYAML
+	usersOut := cfg.Paths.Output("users.csv")
+	reportOut := cfg.Paths.Output("dept-validation-errors-report.csv")
+	if err := validateDepartments(cfg, users, yamlPath, usersOut, reportOut); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Sync teams/department and users to iTop
+	itopClient, err := connectItop(cfg)
+	if err != nil {
+		return err
+	}
+	plan := newPlan(opts.DryRun)
+	if err := syncTeams(cfg, itopClient, yamlPath, plan); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	notSyncedCSV := cfg.Paths.Output("user-not-synchronized.csv")
+	if err := syncUsers(cfg, itopClient, usersOut, yamlPath, notSyncedCSV, export.FullSync, plan); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cfg.ITop.SyncPersonAttributes {
+		personFields, err := cfg.PersonFields()
+		if err != nil {
+			return fmt.Errorf("invalid Person field map: %w", err)
+		}
+		err = synchronizer.SyncPersonAttributes(users, itopClient, personFields, cfg.Exclude, cfg.Paths.Output("person-attribute-changes.csv"), plan)
+		if err != nil {
+			return fmt.Errorf("person attribute sync failed: %w", err)
+		}
+		log.Println("[OK] Person attributes synced successfully.")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cfg.ITop.SyncManagers {
+		var lookup synchronizer.ManagerLookup
+		if !export.FullSync {
+			lookup = export.lookupUsers(cfg.LDAP.Sources)
+		}
+		err = synchronizer.SyncManagers(users, export.Inactive, itopClient, cfg.Exclude, cfg.Paths.Output("manager-sync-report.csv"), lookup, plan)
+		if err != nil {
+			return fmt.Errorf("manager sync failed: %w", err)
+		}
+		log.Println("[OK] Managers synced successfully.")
+	}
+
+	if plan != nil {
+		return writePlan(plan, cfg.Paths.Output("dry-run-plan.csv"))
+	}
+
+	sendErrorReport(cfg, errorReports{
+		DeptReport: reportOut,
+		NotSynced:  notSyncedCSV,
+		Duplicates: cfg.Paths.Output("ldap-duplicate-users.csv"),
+	})
+	return export.saveState(cfg)
+}
+
+// ldapExport is the result of reading every LDAP source
+type ldapExport struct {
+	// Users are the merged users to synchronise; disabled and expired accounts are
+	// left out unless configured otherwise
+	Users []parser.User
+	// FullSync is false when only users changed since the previous run were read
+	FullSync bool
+	// Inactive are the disabled and expired users left out of Users
+	Inactive []parser.User
+
+	state   ldapclient.SyncState
+	highest map[string]string // source name -> highest change marker
+	attrMap parser.AttributeMap
+}
+
+// lookupUsers reads users by DN from the source whose base DNs contain them, with
+// the attributes of the export
+func (e *ldapExport) lookupUsers(sources []ldapclient.Source) synchronizer.ManagerLookup {
+	return func(dns []string) ([]parser.User, error) {
+		var users []parser.User
+		for _, src := range sources {
+			var mine []string
+			for _, dn := range dns {
+				if src.Contains(dn) {
+					mine = append(mine, dn)
+				}
+			}
+			if len(mine) == 0 {
+				continue
+			}
+			found, err := fetchUsersByDN(src, e.attrMap, mine)
+			if err != nil {
+				return nil, fmt.Errorf("LDAP source '%s': %w", src.Name, err)
+			}
+			users = append(users, found...)
+		}
+		return users, nil
+	}
+}
+
+// exportUsers reads every LDAP source and writes the duplicate and inactive user
+// reports. With incremental sync enabled only changed users are read, unless
+// fullSync is set or a full sync is due.
+func exportUsers(cfg *config.Config, fullSync bool) (*ldapExport, error) {
+	attrMap, err := parser.ParseAttributeMap(cfg.LDAP.AttributeMap)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP attribute map: %w", err)
+	}
+	if cfg.ITop.SyncPersonAttributes {
+		personFields, err := cfg.PersonFields()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Person field map: %w", err)
+		}
+		attrMap.Extra = append(attrMap.Extra, synchronizer.LDAPAttributes(personFields)...)
+	}
+	// Department rules may match on attributes that are not read otherwise
+	deptList, err := parser.LoadDepartmentList(cfg.Paths.DepartmentYAML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read department list: %w", err)
+	}
+	rules, err := parser.CompileRules(deptList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid department rules: %w", err)
+	}
+	attrMap.Extra = append(attrMap.Extra, rules.Attributes()...)
+	sources := cfg.LDAP.Sources
+
+	inc := cfg.Sync
+	export := &ldapExport{FullSync: true, state: ldapclient.SyncState{}, highest: make(map[string]string), attrMap: attrMap}
+	if inc.Incremental {
+		if export.state, err = ldapclient.LoadSyncState(inc.StateFile); err != nil {
+			return nil, err
+		}
+		export.FullSync = fullSync || needsFullSync(inc, export.state, sources, time.Now())
+		if export.FullSync {
+			log.Println("[INFO] Incremental sync enabled, running a full sync this time.")
+		}
+	}
+
+	var perSource [][]parser.User
+	for _, src := range sources {
+		scan := changeScan{}
+		if inc.Incremental {
+			scan.Attr = inc.IncrementalAttribute
+			if !export.FullSync {
+				scan.Since = export.state[src.Name].HighestChange
+			}
+		}
+		srcUsers, marker, err := fetchSourceUsers(src, attrMap, scan)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP source '%s': %w", src.Name, err)
+		}
+		metrics.LDAPUsersFetched.Add(float64(len(srcUsers)), src.Name)
+		perSource = append(perSource, srcUsers)
+		export.highest[src.Name] = marker
+	}
+	users, duplicates := parser.MergeUsers(perSource...)
+	if err := os.MkdirAll(cfg.Paths.OutputDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed create output dir: %w", err)
+	}
+	dupOut := cfg.Paths.Output("ldap-duplicate-users.csv")
+	if err := parser.SaveDuplicatesToCSV(duplicates, dupOut); err != nil {
+		return nil, fmt.Errorf("failed to write duplicate report: %w", err)
+	}
+	if len(duplicates) > 0 {
+		log.Printf("[WARN] %d duplicate user(s) across LDAP sources, see %s", len(duplicates), dupOut)
+	}
+
+	// Disabled and expired accounts are kept out of the team sync unless asked otherwise
+	activeUsers, inactiveUsers := parser.SplitActiveUsers(users)
+	inactiveOut := cfg.Paths.Output("inactive-users.csv")
+	if err := parser.SaveInactiveUsersToCSV(inactiveUsers, inactiveOut); err != nil {
+		return nil, fmt.Errorf("failed to write inactive user report: %w", err)
+	}
+	if !cfg.Sync.IncludeInactive {
+		users = activeUsers
+		export.Inactive = inactiveUsers
+		log.Printf("[INFO] Skipping %d disabled/expired account(s), see %s", len(inactiveUsers), inactiveOut)
+	}
+	export.Users = users
+	return export, nil
+}
+
+// saveState records the change markers of an incremental run
+func (e *ldapExport) saveState(cfg *config.Config) error {
+	if !cfg.Sync.Incremental {
+		return nil
+	}
+	now := time.Now()
+	for _, src := range cfg.LDAP.Sources {
+		st := e.state[src.Name]
+		st.ChangeAttr = cfg.Sync.IncrementalAttribute
+		st.HighestChange = e.highest[src.Name]
+		st.LastSync = now
+		if e.FullSync {
+			st.LastFullSync = now
+		}
+		e.state[src.Name] = st
+	}
+	if err := e.state.Save(cfg.Sync.StateFile); err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return nil
+}
+
+// validateDepartments assigns a valid department to every user and writes usersOut
+// and the validation error report
+func validateDepartments(cfg *config.Config, users []parser.User, yamlPath, usersOut, reportOut string) error {
+	matcher, err := cfg.Matching.Matcher()
+	if err != nil {
+		return err
+	}
+	err = parser.ValidateAndAssignDepartment(users, yamlPath, usersOut, reportOut, matcher, cfg.Matching.Threshold, cfg.Matching.OUMode)
+	if err != nil {
+		return fmt.Errorf("department validation failed: %w", err)
+	}
+	data, _ := ioutil.ReadFile(reportOut)
+	metrics.DeptValidationFailures.Add(float64(reportRows(data)))
+	log.Println("[OK] Department validation complete.")
+	return nil
+}
+
+// connectItop creates the iTop client and checks its credentials
+func connectItop(cfg *config.Config) (*itopclient.ITopClient, error) {
+	itopClient, err := newItopClient(cfg.ITop)
+	if err != nil {
+		return nil, err
+	}
+	// Test iTop authentication
+	if err := itopClient.Authenticate(); err != nil {
+		return nil, fmt.Errorf("iTop authentication failed: %w", err)
+	}
+	log.Println("[OK] iTop authentication successful.")
+	return itopClient, nil
+}
+
+func syncTeams(cfg *config.Config, client itopclient.API, yamlPath string, plan *synchronizer.Plan) error {
+	err := synchronizer.SyncTeamsToItop(yamlPath, client, cfg.ITop.OrgID, cfg.ITop.MirrorHierarchy, plan)
+	if err != nil {
+		return fmt.Errorf("team/department sync failed: %w", err)
+	}
+	log.Println("[OK] Teams/Departments synced successfully.")
+	return nil
+}
+
+// syncUsers adds the users of usersCSV to the Team of their valid department.
+// Membership reconciliation is skipped when fullSync is false, since the CSV then
+// only holds the users changed since the previous run.
+func syncUsers(cfg *config.Config, client itopclient.API, usersCSV, yamlPath, notSyncedCSV string, fullSync bool, plan *synchronizer.Plan) error {
+	opts := synchronizer.UserSyncOptions{
+		Reconcile:   cfg.Sync.ReconcileMembership,
+		MaxRemovals: cfg.Sync.MaxRemovals,
+		RemovedCSV:  cfg.Paths.Output("team-membership-removed.csv"),
+		Provision: synchronizer.ProvisionOptions{
+			Persons:      cfg.ITop.ProvisionPersons,
+			UserAccounts: cfg.ITop.ProvisionUserAccounts,
+			Profile:      cfg.ITop.DefaultProfile,
+			DefaultOrgID: cfg.ITop.OrgID,
+			ReportCSV:    cfg.Paths.Output("itop-provisioned-users.csv"),
+		},
+		Workers:   cfg.ITop.Workers,
+		SyncedCSV: cfg.Paths.Output("user-successfully-sync.csv"),
+		Exclude:   cfg.Exclude,
+	}
+	if opts.Reconcile && !fullSync {
+		// Only changed users were read, so the desired membership would be incomplete
+		opts.Reconcile = false
+		log.Println("[INFO] Skipping team membership reconciliation on an incremental run.")
+	}
+	if err := os.MkdirAll(cfg.Paths.OutputDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed create output dir: %w", err)
+	}
+	err := synchronizer.SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV, client, opts, plan)
+	if err != nil {
+		return fmt.Errorf("user sync failed: %w", err)
+	}
+	log.Println("[OK] Users synced successfully.")
+	return nil
+}
+
+func writePlan(plan *synchronizer.Plan, planOut string) error {
+	if err := plan.WriteReport(planOut); err != nil {
+		return fmt.Errorf("failed to write dry-run plan: %w", err)
+	}
+	log.Printf("[OK] Dry-run plan written to %s: %d organization(s) to create, %d organization(s) to move, %d team(s) to create, %d team(s) to move, %d TeamID(s) to rewrite, %d membership(s) to add, %d membership(s) to remove, %d Person(s) to create, %d Person field(s) to update.",
+		planOut, len(plan.OrgsToCreate), len(plan.OrgMoves), len(plan.TeamsToCreate), len(plan.TeamMoves), len(plan.TeamIDRewrites), len(plan.MembershipsToAdd), len(plan.MembershipsToRemove), len(plan.PersonsToCreate), len(plan.PersonUpdates))
+	return nil
+}
+
+// errorReports are the CSV reports attached to the error email; empty paths are skipped
+type errorReports struct {
+	DeptReport string
+	NotSynced  string
+	Duplicates string
+}
+
+// sendErrorReport emails the reports that have at least one row. A failure to send
+// is logged, not returned, so it never fails the sync itself.
+func sendErrorReport(cfg *config.Config, reports errorReports) {
+	attachments := map[string][]byte{}
+	attach := func(path, name string) bool {
+		if path == "" {
+			return false
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || reportRows(data) == 0 {
+			return false
+		}
+		attachments[name] = toXLSX(data)
+		return true
+	}
+	deptHasData := attach(reports.DeptReport, "dept-validation-errors-report.xlsx")
+	userHasData := attach(reports.NotSynced, "user-not-synchronized.xlsx")
+	dupHasData := attach(reports.Duplicates, "ldap-duplicate-users.xlsx")
+
+	// Send email only if ada data error
+	if !deptHasData && !userHasData && !dupHasData {
+		return
+	}
+	if len(cfg.Notification.To) == 0 {
+		log.Println("[INFO] No email recipients configured, skipping the error email.")
+		return
+	}
+	body := buildEmailBody(deptHasData, userHasData, dupHasData)
+	if err := helper.SendErrorMail(cfg.Notification, cfg.Notification.Subject, body, attachments); err != nil {
+		log.Printf("[Error] Failed to send email: %v", err)
+	} else {
+		log.Println("[OK] Email sent successfully.")
+	}
+}
+
+// reportRows counts the rows of a CSV report, not counting the header
+func reportRows(data []byte) int {
+	records, _ := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if len(records) == 0 {
+		return 0
+	}
+	return len(records) - 1
+}
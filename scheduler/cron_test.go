@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr  string
+		after string
+		next  string
+	}{
+		{"0 2 * * *", "2024-06-01 01:59", "2024-06-01 02:00"},
+		{"0 2 * * *", "2024-06-01 02:00", "2024-06-02 02:00"},
+		{"*/15 * * * *", "2024-06-01 10:07", "2024-06-01 10:15"},
+		{"30 8 * * 1-5", "2024-06-01 09:00", "2024-06-03 08:30"}, // Saturday -> Monday
+		{"0 0 * * 7", "2024-06-01 00:00", "2024-06-02 00:00"},    // 7 is Sunday
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 1,15 * *", "2024-06-02 00:00", "2024-06-15 12:00"},
+		// Both day fields restricted: either one matches
+		{"0 0 13 * 5", "2024-06-01 00:00", "2024-06-07 00:00"},
+		{"0 9-17/4 * * *", "2024-06-01 10:00", "2024-06-01 13:00"},
+		// A stepped "*" is not a restriction, so both day fields must match
+		{"0 3 */2 * 1", "2024-06-01 00:00", "2024-06-03 03:00"},
+		{"0 3 */2 * 1", "2024-06-04 00:00", "2024-06-17 03:00"},
+		{"0 3 1 * */2", "2024-06-01 04:00", "2024-08-01 03:00"}, // Jul 1 is a Monday,
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(at(tt.after)); !got.Equal(at(tt.next)) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.after, got.Format("2006-01-02 15:04"), tt.next)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestEvery(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	if got := Every(6 * time.Hour).Next(now); !got.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("Every(6h).Next = %s", got)
+	}
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status describes the runs of a Runner so far
+type Status struct {
+	Running   bool
+	Runs      int
+	Failures  int
+	Skipped   int // runs not started because the previous one was still going
+	LastStart time.Time
+	LastEnd   time.Time
+	LastError string
+	NextRun   time.Time
+}
+
+// Runner executes a job on a schedule and never runs two instances at once
+type Runner struct {
+	Job func(ctx context.Context) error
+
+	running sync.Mutex
+	mu      sync.Mutex
+	status  Status
+	wg      sync.WaitGroup
+}
+
+// Status returns a copy of the current run status
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// RunOnce starts the job unless it is already running and waits for it to finish.
+// It returns false when the run was skipped because of an overlap.
+func (r *Runner) RunOnce(ctx context.Context) bool {
+	if !r.running.TryLock() {
+		r.mu.Lock()
+		r.status.Skipped++
+		r.mu.Unlock()
+		log.Println("[WARN] Previous sync run still in progress, skipping this one.")
+		return false
+	}
+	defer r.running.Unlock()
+
+	r.mu.Lock()
+	r.status.Running = true
+	r.status.LastStart = time.Now()
+	r.mu.Unlock()
+
+	err := r.Job(ctx)
+
+	r.mu.Lock()
+	r.status.Running = false
+	r.status.Runs++
+	r.status.LastEnd = time.Now()
+	r.status.LastError = ""
+	if err != nil {
+		r.status.Failures++
+		r.status.LastError = err.Error()
+	}
+	duration := r.status.LastEnd.Sub(r.status.LastStart)
+	r.mu.Unlock()
+
+	if err != nil {
+		log.Printf("[Error] Sync run failed after %s: %v", duration.Round(time.Second), err)
+	} else {
+		log.Printf("[OK] Sync run finished in %s.", duration.Round(time.Second))
+	}
+	return true
+}
+
+// Start runs the job according to the schedule until ctx is cancelled, then waits
+// for a run in progress to finish. The job receives ctx, so it can stop early.
+// With runNow the first run starts immediately.
+func (r *Runner) Start(ctx context.Context, schedule Schedule, runNow bool) {
+	if runNow {
+		r.RunOnce(ctx)
+	}
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Println("[WARN] Schedule has no next run, stopping.")
+			break
+		}
+		r.mu.Lock()
+		r.status.NextRun = next
+		r.mu.Unlock()
+		log.Printf("[INFO] Next sync run at %s.", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.wg.Wait()
+			return
+		case <-timer.C:
+			// Run in the background so a long run cannot delay the schedule; an
+			// overlapping tick is skipped by RunOnce
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				r.RunOnce(ctx)
+			}()
+		}
+	}
+	r.wg.Wait()
+}
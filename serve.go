@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"ldap-itop/config"
+	"ldap-itop/scheduler"
+)
+
+// serve keeps the process running and syncs on the configured cron expression or
+// interval until SIGTERM/SIGINT. When a status address is set, /healthz, /status
+// and /metrics are served on it. A run in progress is allowed to stop at its next
+// stage boundary before the process exits.
+func serve(cfg *config.Config, opts runOptions) error {
+	schedule, err := loadSchedule(cfg.Schedule)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	last := &runCounts{}
+	runner := &scheduler.Runner{Job: instrumentedJob(func(ctx context.Context) error {
+		return runSync(ctx, cfg, opts)
+	}, last)}
+	if cfg.Schedule.StatusAddr != "" {
+		startStatusServer(ctx, cfg.Schedule.StatusAddr, runner, last)
+	}
+	log.Println("[OK] Sync daemon started.")
+	runner.Start(ctx, schedule, cfg.Schedule.RunOnStart)
+
+	st := runner.Status()
+	log.Printf("[OK] Sync daemon stopped after %d run(s), %d failed, %d skipped.", st.Runs, st.Failures, st.Skipped)
+	return nil
+}
+
+func loadSchedule(cfg config.ScheduleConfig) (scheduler.Schedule, error) {
+	if cfg.Cron != "" {
+		s, err := scheduler.ParseCron(cfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron schedule: %w", err)
+		}
+		return s, nil
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("invalid sync interval %s", cfg.Interval)
+	}
+	return scheduler.Every(cfg.Interval), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"ldap-itop/metrics"
+	"ldap-itop/scheduler"
+)
+
+// runCounts keeps the counter increments of the last finished run for /status
+type runCounts struct {
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func (r *runCounts) set(c map[string]float64) {
+	r.mu.Lock()
+	r.counts = c
+	r.mu.Unlock()
+}
+
+func (r *runCounts) get() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts
+}
+
+// instrumentedJob wraps a sync run to record run metrics and per-run counts
+func instrumentedJob(job func(ctx context.Context) error, last *runCounts) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		before := metrics.Snapshot()
+		start := time.Now()
+		err := job(ctx)
+		end := time.Now()
+
+		result := "success"
+		success := 1.0
+		if err != nil {
+			result, success = "failure", 0
+		}
+		metrics.SyncRuns.Inc(result)
+		metrics.LastRunTimestamp.Set(float64(end.Unix()))
+		metrics.LastRunDuration.Set(end.Sub(start).Seconds())
+		metrics.LastRunSuccess.Set(success)
+		last.set(metrics.CounterDiff(before))
+		return err
+	}
+}
+
+type statusResponse struct {
+	Running         bool               `json:"running"`
+	Runs            int                `json:"runs"`
+	Failures        int                `json:"failures"`
+	Skipped         int                `json:"skipped"`
+	LastStart       *time.Time         `json:"last_start,omitempty"`
+	LastEnd         *time.Time         `json:"last_end,omitempty"`
+	LastDurationSec float64            `json:"last_duration_seconds"`
+	LastError       string             `json:"last_error,omitempty"`
+	NextRun         *time.Time         `json:"next_run,omitempty"`
+	LastRunCounts   map[string]float64 `json:"last_run_counts"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// startStatusServer serves /healthz, /status and /metrics until ctx is cancelled
+func startStatusServer(ctx context.Context, addr string, runner *scheduler.Runner, last *runCounts) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		st := runner.Status()
+		resp := statusResponse{
+			Running:       st.Running,
+			Runs:          st.Runs,
+			Failures:      st.Failures,
+			Skipped:       st.Skipped,
+			LastStart:     optionalTime(st.LastStart),
+			LastEnd:       optionalTime(st.LastEnd),
+			LastError:     st.LastError,
+			NextRun:       optionalTime(st.NextRun),
+			LastRunCounts: last.get(),
+		}
+		if !st.LastEnd.IsZero() && !st.Running {
+			resp.LastDurationSec = st.LastEnd.Sub(st.LastStart).Seconds()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("[OK] Status server listening on %s.", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[Error] Status server failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+}
@@ -1,26 +1,32 @@
 package synchronizer
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"strings"
 
 	itopclient "ldap-itop/itopclient"
+	"ldap-itop/metrics"
+	"ldap-itop/parser"
 
 	"gopkg.in/yaml.v2"
 )
 
-type DepartmentYAML struct {
-	DepartmentName string   `yaml:"DepartmentName"`
-	SubList        []string `yaml:"SubList"`
-	TeamID         string   `yaml:"TeamID,omitempty"`
-}
+// DepartmentYAML is the parser's type, so rewriting the list keeps every field
+type DepartmentYAML = parser.DepartmentYAML
 
-type DepartmentYAMLList []DepartmentYAML
+type DepartmentYAMLList = parser.DepartmentYAMLList
 
-func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID string) error {
+// SyncTeamsToItop makes sure every department in the YAML has a Team in iTop and
+// records the TeamIDs back into the YAML. When plan is non-nil nothing is created
+// and the YAML is left untouched; the changes are recorded in the plan instead.
+//
+// Teams are created in orgID. With mirror set, a department with an Organization
+// gets its Team in that Organization instead: missing Organizations are created
+// under their Parent (orgID when none), existing Teams are moved there, and the
+// OrganizationIDs are recorded in the YAML as well.
+func SyncTeamsToItop(yamlPath string, client itopclient.API, orgID string, mirror bool, plan *Plan) error {
 	// Read YAML
 	data, err := ioutil.ReadFile(yamlPath)
 	if err != nil {
@@ -31,35 +37,28 @@ func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID strin
 		return err
 	}
 
-	// Get existing teams from iTop
-	params := map[string]interface{}{
-		"class":         "Team",
-		"key":           "SELECT Team",
-		"output_fields": "id,name",
+	var orgs *orgResolver
+	if mirror {
+		if orgs, err = newOrgResolver(client, deptList, orgID, plan); err != nil {
+			return err
+		}
 	}
-	resp, err := client.Post("core/get", params)
+
+	// Get existing teams from iTop
+	teams, err := client.GetTeams()
 	if err != nil {
 		return err
 	}
-	var result struct {
-		Objects map[string]struct {
-			Fields struct {
-				ID   string `json:"id"`
-				Name string `json:"name"`
-			} `json:"fields"`
-		} `json:"objects"`
-	}
-	if err := json.Unmarshal(resp, &result); err != nil {
-		return err
-	}
 
 	existingTeams := make(map[string]string) // name -> id
 	existingTeamIDs := make(map[string]bool) // id -> true
-	for _, obj := range result.Objects {
-		name := strings.TrimSpace(obj.Fields.Name)
+	teamOrgs := make(map[string]string)      // id -> org_id
+	for _, t := range teams {
+		name := strings.TrimSpace(t.Name)
 		if name != "" {
-			existingTeams[strings.ToUpper(name)] = obj.Fields.ID
-			existingTeamIDs[obj.Fields.ID] = true
+			existingTeams[strings.ToUpper(name)] = t.ID
+			existingTeamIDs[t.ID] = true
+			teamOrgs[t.ID] = t.OrgID
 		}
 	}
 
@@ -69,10 +68,26 @@ func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID strin
 			continue
 		}
 		teamName := d.DepartmentName
+		teamOrgID := orgID
+		mirrored := mirror && strings.TrimSpace(d.Organization) != ""
+		if mirrored {
+			if teamOrgID, err = orgs.resolve(d.Organization); err != nil {
+				return err
+			}
+			if !isPlannedOrgID(teamOrgID) && d.OrganizationID != teamOrgID && plan == nil {
+				deptList[i].OrganizationID = teamOrgID
+				changed = true
+			}
+		}
 		// 1. If TeamID exists in YAML, check if still exists in iTop
 		if d.TeamID != "" {
 			if _, found := existingTeamIDs[d.TeamID]; found {
-				// TeamID still valid, skip
+				// TeamID still valid, only the organization may have to change
+				if mirrored {
+					if err := moveTeam(client, teamName, d.TeamID, teamOrgs[d.TeamID], teamOrgID, plan); err != nil {
+						return err
+					}
+				}
 				continue
 			} else {
 				log.Printf("[INFO] TeamID %s for '%s' not found in iTop, will create new.", d.TeamID, teamName)
@@ -81,7 +96,17 @@ func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID strin
 		// 2. If not, check by name
 		teamID, exists := existingTeams[strings.ToUpper(teamName)]
 		if exists {
+			if mirrored {
+				if err := moveTeam(client, teamName, teamID, teamOrgs[teamID], teamOrgID, plan); err != nil {
+					return err
+				}
+			}
 			if d.TeamID != teamID {
+				if plan != nil {
+					plan.rewriteTeamID(teamName, d.TeamID, teamID)
+					log.Printf("[DRY-RUN] Would update TeamID of '%s' in YAML from '%s' to %s.", teamName, d.TeamID, teamID)
+					continue
+				}
 				deptList[i].TeamID = teamID
 				changed = true
 				log.Printf("[INFO] Found team '%s' in iTop with ID %s, updating YAML.", teamName, teamID)
@@ -89,48 +114,28 @@ func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID strin
 			continue
 		}
 		// 3. Create team if not exists
-		params := map[string]interface{}{
-			"class":         "Team",
-			"comment":       fmt.Sprintf("Creating department %s", teamName),
-			"output_fields": "id,name",
-			"fields": map[string]interface{}{
-				"name":   teamName,
-				"org_id": orgID,
-				"status": "active",
-			},
+		if plan != nil {
+			plan.addTeam(teamName, teamOrgID)
+			log.Printf("[DRY-RUN] Would create team '%s' in organization %s.", teamName, teamOrgID)
+			continue
 		}
-		resp, err := client.Post("core/create", params)
+		teamID, err = client.CreateTeam(teamName, teamOrgID, fmt.Sprintf("Creating department %s", teamName))
 		if err != nil {
-			log.Printf("[ERROR] Failed to create team %s: %v\nResponse: %s", teamName, err, string(resp))
+			log.Printf("[ERROR] Failed to create team %s: %v", teamName, err)
 			return fmt.Errorf("failed to create team %s: %w", teamName, err)
 		}
-		var createResult struct {
-			Objects map[string]struct {
-				Fields struct {
-					ID   string `json:"id"`
-					Name string `json:"name"`
-				} `json:"fields"`
-			} `json:"objects"`
-			Message string `json:"message"`
-			Code    int    `json:"code"`
-		}
-		if err := json.Unmarshal(resp, &createResult); err != nil {
-			log.Printf("[ERROR] Failed to parse create team response for %s: %v\nResponse: %s", teamName, err, string(resp))
-			return err
-		}
-		if createResult.Code != 0 {
-			log.Printf("[ERROR] iTop API error creating team %s: %s (code %d)\nResponse: %s", teamName, createResult.Message, createResult.Code, string(resp))
-			return fmt.Errorf("iTop API error creating team %s: %s (code %d)", teamName, createResult.Message, createResult.Code)
-		}
-		for _, obj := range createResult.Objects {
-			deptList[i].TeamID = obj.Fields.ID
-			changed = true
-			log.Printf("[OK] Created team '%s' with ID %s", teamName, obj.Fields.ID)
-			break
-		}
+		deptList[i].TeamID = teamID
+		changed = true
+		metrics.TeamsCreated.Inc()
+		log.Printf("[OK] Created team '%s' with ID %s in organization %s", teamName, teamID, teamOrgID)
+	}
+
+	if orgs != nil && len(orgs.refused) > 0 {
+		log.Printf("[WARN] %d organization(s) kept under their current parent, the declared Parent would create a cycle: %s",
+			len(orgs.refused), strings.Join(orgs.refused, ", "))
 	}
 
-	if changed {
+	if changed && plan == nil {
 		out, err := yaml.Marshal(&deptList)
 		if err != nil {
 			return err
@@ -141,3 +146,21 @@ func SyncTeamsToItop(yamlPath string, client *itopclient.ITopClient, orgID strin
 	}
 	return nil
 }
+
+// moveTeam puts an existing Team in the organization of its department
+func moveTeam(client itopclient.API, deptName, teamID, fromOrgID, toOrgID string, plan *Plan) error {
+	if fromOrgID == toOrgID {
+		return nil
+	}
+	if plan != nil {
+		plan.moveTeam(PlannedTeamMove{DepartmentName: deptName, TeamID: teamID, OldOrgID: fromOrgID, NewOrgID: toOrgID})
+		log.Printf("[DRY-RUN] Would move team '%s' from organization %s to %s.", deptName, fromOrgID, toOrgID)
+		return nil
+	}
+	if err := client.MoveTeam(teamID, toOrgID, fmt.Sprintf("Moving department %s to its organization", deptName)); err != nil {
+		log.Printf("[ERROR] Failed to move team %s: %v", deptName, err)
+		return fmt.Errorf("failed to move team %s: %w", deptName, err)
+	}
+	log.Printf("[OK] Moved team '%s' from organization %s to %s", deptName, fromOrgID, toOrgID)
+	return nil
+}
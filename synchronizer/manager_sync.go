@@ -0,0 +1,173 @@
+package synchronizer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	itopclient "ldap-itop/itopclient"
+	"ldap-itop/parser"
+)
+
+// maxManagerLookups bounds how many levels of managers are read from LDAP to
+// complete the manager chains of an incremental run
+const maxManagerLookups = 10
+
+// ManagerLookup reads the users with the given DNs from LDAP. DNs that do not exist
+// or lie outside the synced base DNs are left out of the result.
+type ManagerLookup func(dns []string) ([]parser.User, error)
+
+// SyncManagers sets Person.manager_id from the AD manager attribute. The manager DN
+// is resolved to a user of the same run and then to that user's Person in iTop.
+// Managers outside the synced base DNs, managers without an iTop User and manager
+// cycles are reported and left untouched. On an incremental run users holds only
+// part of the directory, so the managers missing from it (and their own managers,
+// to find cycles) are read with lookup; lookup is nil on a full sync. Users whose
+// CN is in exclude are skipped. inactive are the disabled and expired users that
+// are not synced themselves but can still be the manager of a synced user. When
+// plan is non-nil nothing is updated.
+func SyncManagers(users, inactive []parser.User, client itopclient.API, exclude []string, reportCSV string, lookup ManagerLookup, plan *Plan) error {
+	excludeMap := excludeSet(exclude)
+
+	reportF, err := os.Create(reportCSV)
+	if err != nil {
+		return err
+	}
+	defer reportF.Close()
+	reportW := csv.NewWriter(reportF)
+	defer reportW.Flush()
+	reportW.Write([]string{"nama", "sAMAccountName", "person_id", "manager_dn", "manager_sAMAccountName", "manager_person_id", "status"})
+
+	all := append(append([]parser.User{}, users...), inactive...)
+	byDN := make(map[string]parser.User, len(all))
+	for _, u := range all {
+		if u.DN != "" {
+			byDN[strings.ToLower(u.DN)] = u
+		}
+	}
+	if lookup != nil {
+		asked := make(map[string]bool)
+		for level := 0; level < maxManagerLookups; level++ {
+			var missing []string
+			for _, u := range all {
+				dn := strings.ToLower(u.ManagerDN)
+				if _, ok := byDN[dn]; dn != "" && !ok && !asked[dn] {
+					asked[dn] = true
+					missing = append(missing, u.ManagerDN)
+				}
+			}
+			if len(missing) == 0 {
+				break
+			}
+			found, err := lookup(missing)
+			if err != nil {
+				return fmt.Errorf("failed to read managers from LDAP: %w", err)
+			}
+			log.Printf("[INFO] Read %d of %d manager(s) not in this incremental run from LDAP.", len(found), len(missing))
+			for _, u := range found {
+				all = append(all, u)
+				byDN[strings.ToLower(u.DN)] = u
+			}
+		}
+	}
+	inCycle := findManagerCycles(all, byDN)
+
+	contactIDs, err := client.GetUserContactIDs()
+	if err != nil {
+		return fmt.Errorf("failed to read iTop users: %w", err)
+	}
+
+	for _, user := range users {
+		if excludeMap[user.CN] || user.ManagerDN == "" || user.SAMAccountName == "" {
+			continue
+		}
+		row := func(personID, mgrLogin, mgrID, status string) {
+			reportW.Write([]string{user.CN, user.SAMAccountName, personID, user.ManagerDN, mgrLogin, mgrID, status})
+		}
+		if inCycle[strings.ToLower(user.DN)] {
+			row("", "", "", "Manager cycle detected")
+			continue
+		}
+		mgr, ok := byDN[strings.ToLower(user.ManagerDN)]
+		if !ok {
+			row("", "", "", "Manager outside synced base DN")
+			continue
+		}
+		personID := contactIDs[strings.ToLower(user.SAMAccountName)]
+		if personID == "" {
+			continue
+		}
+		mgrID := contactIDs[strings.ToLower(mgr.SAMAccountName)]
+		if mgrID == "" {
+			status := "Manager not found in iTop (by login)"
+			if mgr.Status != parser.AccountActive && mgr.Status != "" {
+				status = fmt.Sprintf("Manager account %s, not found in iTop (by login)", mgr.Status)
+			}
+			row(personID, mgr.SAMAccountName, "", status)
+			continue
+		}
+		current, err := client.GetPerson(personID, []string{"manager_id"})
+		if itopclient.IsUnavailable(err) {
+			return fmt.Errorf("failed to read Person::%s: %w", personID, err)
+		}
+		if err != nil {
+			row(personID, mgr.SAMAccountName, mgrID, "Failed to read Person: "+err.Error())
+			continue
+		}
+		if current["manager_id"] == mgrID {
+			continue
+		}
+		if plan != nil {
+			plan.updatePerson(PlannedPersonUpdate{PersonID: personID, SAMAccountName: user.SAMAccountName, Field: "manager_id", OldValue: current["manager_id"], NewValue: mgrID})
+			row(personID, mgr.SAMAccountName, mgrID, "Would be updated (dry-run)")
+			continue
+		}
+		err = client.UpdatePerson(personID, map[string]string{"manager_id": mgrID},
+			fmt.Sprintf("Sinkronisasi manager dari AD: %s", mgr.SAMAccountName))
+		if err != nil {
+			row(personID, mgr.SAMAccountName, mgrID, "Failed to update Person: "+err.Error())
+			continue
+		}
+		log.Printf("[OK] Set manager of Person::%s (%s) to Person::%s (%s)", personID, user.CN, mgrID, mgr.CN)
+		row(personID, mgr.SAMAccountName, mgrID, fmt.Sprintf("Updated (previous manager_id: %s)", current["manager_id"]))
+	}
+	return nil
+}
+
+// findManagerCycles returns the DNs (lowercased) of users that are part of a
+// manager chain leading back to themselves, including users who are their own manager
+func findManagerCycles(users []parser.User, byDN map[string]parser.User) map[string]bool {
+	inCycle := make(map[string]bool)
+	done := make(map[string]bool)
+	for _, u := range users {
+		start := strings.ToLower(u.DN)
+		if start == "" || done[start] {
+			continue
+		}
+		// Walk up the chain remembering the position of each DN on the current path
+		pos := make(map[string]int)
+		var path []string
+		cur := start
+		for cur != "" && !done[cur] {
+			if i, seen := pos[cur]; seen {
+				for _, dn := range path[i:] {
+					inCycle[dn] = true
+				}
+				break
+			}
+			pos[cur] = len(path)
+			path = append(path, cur)
+			next, ok := byDN[cur]
+			if !ok {
+				break
+			}
+			cur = strings.ToLower(next.ManagerDN)
+		}
+		for _, dn := range path {
+			done[dn] = true
+		}
+	}
+	return inCycle
+}
@@ -0,0 +1,150 @@
+package synchronizer
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	itopclient "ldap-itop/itopclient"
+	"ldap-itop/parser"
+)
+
+// reportStatus returns the status column of the report row of login
+func reportStatus(t *testing.T, report, login string) string {
+	t.Helper()
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.Contains(l, ","+login+",") {
+			return l[strings.LastIndex(l, ",")+1:]
+		}
+	}
+	return ""
+}
+
+func newManagerClient(logins ...string) *itopclient.FakeClient {
+	client := itopclient.NewFakeClient()
+	for i, login := range logins {
+		id := strconv.Itoa(i + 1)
+		client.AddUser(login, id)
+		client.AddPerson(id, itopclient.Person{Name: login, OrgID: "1"})
+	}
+	return client
+}
+
+func TestSyncManagersDisabledManager(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "managers.csv")
+	users := []parser.User{
+		{DN: "CN=Alice,OU=Staff,DC=corp", CN: "Alice", SAMAccountName: "alice", ManagerDN: "CN=Dave,OU=Staff,DC=corp", Status: parser.AccountActive},
+		{DN: "CN=Bob,OU=Staff,DC=corp", CN: "Bob", SAMAccountName: "bob", ManagerDN: "CN=Erin,OU=Staff,DC=corp", Status: parser.AccountActive},
+		{DN: "CN=Carol,OU=Staff,DC=corp", CN: "Carol", SAMAccountName: "carol", ManagerDN: "CN=Zed,OU=Elsewhere,DC=corp", Status: parser.AccountActive},
+	}
+	inactive := []parser.User{
+		{DN: "CN=Dave,OU=Staff,DC=corp", CN: "Dave", SAMAccountName: "dave", Status: parser.AccountDisabled},
+		{DN: "CN=Erin,OU=Staff,DC=corp", CN: "Erin", SAMAccountName: "erin", Status: parser.AccountExpired},
+	}
+	client := itopclient.NewFakeClient()
+	for id, login := range map[string]string{"1": "alice", "2": "bob", "3": "carol", "4": "dave"} {
+		client.AddUser(login, id)
+		client.AddPerson(id, itopclient.Person{Name: login, OrgID: "1"})
+	}
+
+	if err := SyncManagers(users, inactive, client, nil, report, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A disabled manager that still has a Person is linked
+	if got, _ := client.GetPerson("1", []string{"manager_id"}); got["manager_id"] != "4" {
+		t.Errorf("Alice manager_id = %q, want 4", got["manager_id"])
+	}
+	data, _ := os.ReadFile(report)
+	lines := strings.Split(string(data), "\n")
+	check := func(login, status string) {
+		t.Helper()
+		for _, l := range lines {
+			if strings.Contains(l, ","+login+",") {
+				if !strings.Contains(l, status) {
+					t.Errorf("%s: %s, want status %q", login, l, status)
+				}
+				return
+			}
+		}
+		t.Errorf("%s is missing from the report", login)
+	}
+	check("bob", "Manager account expired, not found in iTop")
+	check("carol", "Manager outside synced base DN")
+}
+
+func TestSyncManagersCycle(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "managers.csv")
+	users := []parser.User{
+		{DN: "CN=Alice,DC=corp", CN: "Alice", SAMAccountName: "alice", ManagerDN: "CN=Bob,DC=corp"},
+		{DN: "CN=Bob,DC=corp", CN: "Bob", SAMAccountName: "bob", ManagerDN: "CN=Carol,DC=corp"},
+		{DN: "CN=Carol,DC=corp", CN: "Carol", SAMAccountName: "carol", ManagerDN: "cn=alice,dc=corp"},
+		{DN: "CN=Dave,DC=corp", CN: "Dave", SAMAccountName: "dave", ManagerDN: "CN=Dave,DC=corp"},
+		{DN: "CN=Erin,DC=corp", CN: "Erin", SAMAccountName: "erin", ManagerDN: "CN=Alice,DC=corp"},
+	}
+	client := newManagerClient("alice", "bob", "carol", "dave", "erin")
+
+	if err := SyncManagers(users, nil, client, nil, report, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range []string{"alice", "bob", "carol", "dave"} {
+		if got := reportStatus(t, report, login); got != "Manager cycle detected" {
+			t.Errorf("%s: status %q, want a reported cycle", login, got)
+		}
+	}
+	// Erin reports to someone in the cycle but is not part of it
+	if got, _ := client.GetPerson("5", []string{"manager_id"}); got["manager_id"] != "1" {
+		t.Errorf("Erin manager_id = %q, want Alice (1)", got["manager_id"])
+	}
+	if client.Updates != 1 {
+		t.Errorf("%d update(s), want only Erin's", client.Updates)
+	}
+}
+
+func TestSyncManagersIncrementalLookup(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "managers.csv")
+	// Only Alice, Bob and Carol changed since the last run
+	users := []parser.User{
+		{DN: "CN=Alice,DC=corp", CN: "Alice", SAMAccountName: "alice", ManagerDN: "CN=Dave,DC=corp"},
+		{DN: "CN=Bob,DC=corp", CN: "Bob", SAMAccountName: "bob", ManagerDN: "CN=Erin,DC=corp"},
+		{DN: "CN=Carol,DC=corp", CN: "Carol", SAMAccountName: "carol", ManagerDN: "CN=Zed,OU=Elsewhere,DC=other"},
+	}
+	directory := map[string]parser.User{
+		"CN=Dave,DC=corp": {DN: "CN=Dave,DC=corp", CN: "Dave", SAMAccountName: "dave"},
+		// Erin is unchanged and reports to Bob: the cycle is only visible through LDAP
+		"CN=Erin,DC=corp": {DN: "CN=Erin,DC=corp", CN: "Erin", SAMAccountName: "erin", ManagerDN: "CN=Bob,DC=corp"},
+	}
+	var asked []string
+	lookup := func(dns []string) ([]parser.User, error) {
+		asked = append(asked, dns...)
+		var found []parser.User
+		for _, dn := range dns {
+			if u, ok := directory[dn]; ok {
+				found = append(found, u)
+			}
+		}
+		return found, nil
+	}
+	client := newManagerClient("alice", "bob", "carol", "dave", "erin")
+
+	if err := SyncManagers(users, nil, client, nil, report, lookup, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := client.GetPerson("1", []string{"manager_id"}); got["manager_id"] != "4" {
+		t.Errorf("Alice manager_id = %q, want Dave (4) read from LDAP", got["manager_id"])
+	}
+	if got := reportStatus(t, report, "bob"); got != "Manager cycle detected" {
+		t.Errorf("bob: status %q, want a reported cycle", got)
+	}
+	if got := reportStatus(t, report, "carol"); got != "Manager outside synced base DN" {
+		t.Errorf("carol: status %q, want outside synced base DN", got)
+	}
+	if len(asked) != 3 {
+		t.Errorf("looked up %v, want each missing manager once", asked)
+	}
+}
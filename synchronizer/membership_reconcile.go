@@ -0,0 +1,134 @@
+package synchronizer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	itopclient "ldap-itop/itopclient"
+	"ldap-itop/metrics"
+)
+
+type membershipRemoval struct {
+	TeamID         string
+	DepartmentName string
+	Member         itopclient.TeamMember
+}
+
+// reconcileTeamMembers removes Persons from managed teams when their AD department
+// now resolves to another team. Members that AD puts in no team this run (unmatched
+// department, missing from the export, or not from AD at all) are kept and reported.
+// If more than opts.MaxRemovals removals are needed the whole step is skipped, since
+// that usually points at a broken LDAP export rather than a wave of department changes.
+func reconcileTeamMembers(client itopclient.API, managed map[string]string, desired map[string]map[string]bool, protected map[string]bool, opts UserSyncOptions, plan *Plan) error {
+	removedF, err := os.Create(opts.RemovedCSV)
+	if err != nil {
+		return err
+	}
+	defer removedF.Close()
+	removedW := csv.NewWriter(removedF)
+	defer removedW.Flush()
+	removedW.Write([]string{"team_id", "department", "person_id", "nama", "status"})
+
+	teamIDs := make([]string, 0, len(managed))
+	for id := range managed {
+		teamIDs = append(teamIDs, id)
+	}
+	sort.Strings(teamIDs)
+
+	// Read again after the additions so the removals are computed from iTop's current state
+	current, err := client.GetTeamsMembers(teamIDs)
+	if err != nil {
+		return fmt.Errorf("failed to read iTop team members: %w", err)
+	}
+	// assigned holds every Person that AD puts in some managed team this run
+	assigned := make(map[string]bool)
+	for _, ids := range desired {
+		for id := range ids {
+			assigned[id] = true
+		}
+	}
+	remaining := make(map[string][]itopclient.TeamMember) // TeamID -> members to keep
+	var removals []membershipRemoval
+	var unknown []membershipRemoval
+	for _, teamID := range teamIDs {
+		members, ok := current[teamID]
+		if !ok {
+			log.Printf("[ERROR] Team::%s not found in iTop, skipping reconciliation for it.", teamID)
+			continue
+		}
+		var keep []itopclient.TeamMember
+		for _, m := range members {
+			if desired[teamID][m.PersonID] || protected[m.PersonID] {
+				keep = append(keep, m)
+				continue
+			}
+			if !assigned[m.PersonID] {
+				keep = append(keep, m)
+				unknown = append(unknown, membershipRemoval{TeamID: teamID, DepartmentName: managed[teamID], Member: m})
+				continue
+			}
+			removals = append(removals, membershipRemoval{TeamID: teamID, DepartmentName: managed[teamID], Member: m})
+		}
+		remaining[teamID] = keep
+	}
+
+	for _, r := range unknown {
+		removedW.Write([]string{r.TeamID, r.DepartmentName, r.Member.PersonID, r.Member.PersonName, "Kept: not assigned to any team by AD this run (unmatched department, not exported or not from AD)"})
+	}
+	if len(unknown) > 0 {
+		log.Printf("[INFO] %d team member(s) without a team from AD this run are kept, see %s.", len(unknown), opts.RemovedCSV)
+	}
+
+	if opts.MaxRemovals >= 0 && len(removals) > opts.MaxRemovals {
+		log.Printf("[ERROR] %d membership removal(s) needed but the limit is %d, no one is removed this run.", len(removals), opts.MaxRemovals)
+		for _, r := range removals {
+			removedW.Write([]string{r.TeamID, r.DepartmentName, r.Member.PersonID, r.Member.PersonName, fmt.Sprintf("Skipped: removal cap of %d exceeded", opts.MaxRemovals)})
+		}
+		return nil
+	}
+
+	if plan != nil {
+		for _, r := range removals {
+			plan.removeMembership(PlannedMembership{
+				CN:             r.Member.PersonName,
+				PersonID:       r.Member.PersonID,
+				TeamID:         r.TeamID,
+				DepartmentName: r.DepartmentName,
+			})
+			removedW.Write([]string{r.TeamID, r.DepartmentName, r.Member.PersonID, r.Member.PersonName, "Would be removed from team (dry-run)"})
+		}
+		return nil
+	}
+
+	byTeam := make(map[string][]membershipRemoval)
+	var changedTeams []string
+	for _, r := range removals {
+		if len(byTeam[r.TeamID]) == 0 {
+			changedTeams = append(changedTeams, r.TeamID)
+		}
+		byTeam[r.TeamID] = append(byTeam[r.TeamID], r)
+	}
+	statuses := make([]string, len(changedTeams))
+	forEachTeam(changedTeams, opts.Workers, func(i int, teamID string) {
+		teamRemovals := byTeam[teamID]
+		status := "Removed from team"
+		_, err := client.UpdateTeamMembers(teamID, remaining[teamID],
+			fmt.Sprintf("Menghapus %d anggota yang tidak lagi berada di department %s", len(teamRemovals), managed[teamID]))
+		if err != nil {
+			status = "Failed to remove from team: " + err.Error()
+		} else {
+			metrics.MembershipsRemoved.Add(float64(len(teamRemovals)))
+		}
+		statuses[i] = status
+		log.Printf("[INFO] Team::%s (%s): %d member(s) - %s", teamID, managed[teamID], len(teamRemovals), status)
+	})
+	for i, teamID := range changedTeams {
+		for _, r := range byTeam[teamID] {
+			removedW.Write([]string{r.TeamID, r.DepartmentName, r.Member.PersonID, r.Member.PersonName, statuses[i]})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package synchronizer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	itopclient "ldap-itop/itopclient"
+	"ldap-itop/metrics"
+)
+
+// orgResolver finds or creates the iTop Organizations named in the department
+// list and puts them under their Parent. Organizations without a Parent belong
+// under the root organization.
+type orgResolver struct {
+	client    itopclient.API
+	plan      *Plan
+	rootOrgID string
+
+	byName    map[string]itopclient.Organization // upper-cased name -> organization
+	byID      map[string]itopclient.Organization
+	cached    map[string]string // upper-cased name -> OrganizationID of the YAML
+	parents   map[string]string // upper-cased name -> declared Parent
+	resolved  map[string]string // upper-cased name -> id
+	resolving map[string]bool
+	refused   []string // Organizations not moved because the move would create a cycle
+}
+
+// CheckHierarchy reports a department with a Parent but no Organization, and an
+// Organization declared with different Parents
+func CheckHierarchy(deptList DepartmentYAMLList) error {
+	_, err := declaredParents(deptList)
+	return err
+}
+
+func declaredParents(deptList DepartmentYAMLList) (map[string]string, error) {
+	parents := make(map[string]string)
+	for _, d := range deptList {
+		org := strings.TrimSpace(d.Organization)
+		parent := strings.TrimSpace(d.Parent)
+		if org == "" {
+			if parent != "" {
+				return nil, fmt.Errorf("department %s has a Parent but no Organization", d.DepartmentName)
+			}
+			continue
+		}
+		key := strings.ToUpper(org)
+		if prev, ok := parents[key]; ok && parent != "" && prev != "" && !strings.EqualFold(prev, parent) {
+			return nil, fmt.Errorf("organization %s has two parents: %s and %s", org, prev, parent)
+		}
+		if parent != "" || parents[key] == "" {
+			parents[key] = parent
+		}
+	}
+	return parents, nil
+}
+
+func newOrgResolver(client itopclient.API, deptList DepartmentYAMLList, rootOrgID string, plan *Plan) (*orgResolver, error) {
+	parents, err := declaredParents(deptList)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := client.GetOrganizations()
+	if err != nil {
+		return nil, err
+	}
+	r := &orgResolver{
+		client:    client,
+		plan:      plan,
+		rootOrgID: rootOrgID,
+		byName:    make(map[string]itopclient.Organization),
+		byID:      make(map[string]itopclient.Organization),
+		cached:    make(map[string]string),
+		parents:   parents,
+		resolved:  make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+	for _, o := range orgs {
+		if name := strings.TrimSpace(o.Name); name != "" {
+			r.byName[strings.ToUpper(name)] = o
+		}
+		r.byID[o.ID] = o
+	}
+	// A cached OrganizationID that still exists wins over the name, so renaming
+	// an Organization in iTop does not create a new one
+	for _, d := range deptList {
+		if _, ok := r.byID[d.OrganizationID]; ok && d.Organization != "" && d.OrganizationID != "" {
+			r.cached[strings.ToUpper(strings.TrimSpace(d.Organization))] = d.OrganizationID
+		}
+	}
+	return r, nil
+}
+
+// resolve returns the id of the named Organization, creating it and its parents
+// when missing and moving an existing one under the right parent. With a plan the
+// changes are only recorded.
+func (r *orgResolver) resolve(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	key := strings.ToUpper(name)
+	if id, ok := r.resolved[key]; ok {
+		return id, nil
+	}
+	if r.resolving[key] {
+		return "", fmt.Errorf("organization %s is its own parent", name)
+	}
+	r.resolving[key] = true
+	defer delete(r.resolving, key)
+
+	parentID := r.rootOrgID
+	parent := r.parents[key]
+	if parent != "" {
+		var err error
+		if parentID, err = r.resolve(parent); err != nil {
+			return "", err
+		}
+	}
+
+	id, ok := r.cached[key]
+	if !ok {
+		id = r.byName[key].ID
+	}
+	if id != "" {
+		// Only a declared Parent moves an existing Organization, so subsidiaries of
+		// other sources and hierarchies kept by hand in iTop stay where they are
+		if parent != "" {
+			if err := r.reparent(name, r.byID[id], parentID); err != nil {
+				return "", err
+			}
+		}
+		r.resolved[key] = id
+		return id, nil
+	}
+
+	if r.plan != nil {
+		id := r.plan.addOrganization(name, parentID)
+		r.resolved[key] = id
+		log.Printf("[DRY-RUN] Would create organization '%s' under organization %s.", name, parentID)
+		return id, nil
+	}
+	id, err := r.client.CreateOrganization(name, parentID, fmt.Sprintf("Creating organization %s", name))
+	if err != nil {
+		log.Printf("[ERROR] Failed to create organization %s: %v", name, err)
+		return "", fmt.Errorf("failed to create organization %s: %w", name, err)
+	}
+	r.resolved[key] = id
+	metrics.OrganizationsCreated.Inc()
+	log.Printf("[OK] Created organization '%s' with ID %s under organization %s", name, id, parentID)
+	return id, nil
+}
+
+// reparent moves an existing Organization under parentID when it sits elsewhere.
+// A move that would put the Organization under itself or one of its descendants
+// is refused and reported.
+func (r *orgResolver) reparent(name string, o itopclient.Organization, parentID string) error {
+	if o.ParentID == parentID {
+		return nil
+	}
+	if r.isDescendant(parentID, o.ID) {
+		r.refused = append(r.refused, name)
+		log.Printf("[WARN] Not moving organization '%s' under %s: %s is the organization itself or one of its descendants in iTop.", name, parentID, parentID)
+		return nil
+	}
+	if r.plan != nil {
+		r.plan.moveOrganization(PlannedOrgMove{Name: name, OrgID: o.ID, OldParentID: o.ParentID, NewParentID: parentID})
+		log.Printf("[DRY-RUN] Would move organization '%s' from parent %s to %s.", name, o.ParentID, parentID)
+	} else {
+		if err := r.client.MoveOrganization(o.ID, parentID, fmt.Sprintf("Moving organization %s under its parent", name)); err != nil {
+			log.Printf("[ERROR] Failed to move organization %s: %v", name, err)
+			return fmt.Errorf("failed to move organization %s: %w", name, err)
+		}
+		log.Printf("[OK] Moved organization '%s' from parent %s to %s", name, o.ParentID, parentID)
+	}
+	// Later cycle checks see the new hierarchy
+	o.ParentID = parentID
+	r.byID[o.ID] = o
+	return nil
+}
+
+// isDescendant tells whether id is ancestorID or sits below it in iTop
+func (r *orgResolver) isDescendant(id, ancestorID string) bool {
+	seen := make(map[string]bool)
+	for id != "" && !seen[id] {
+		if id == ancestorID {
+			return true
+		}
+		seen[id] = true
+		id = r.byID[id].ParentID
+	}
+	return false
+}
+
+func isPlannedOrgID(orgID string) bool {
+	return strings.HasPrefix(orgID, plannedOrgPrefix)
+}
@@ -0,0 +1,142 @@
+package synchronizer
+
+import (
+	"testing"
+
+	itopclient "ldap-itop/itopclient"
+)
+
+const hierarchyYAML = `- DepartmentName: SMT
+  SubList:
+  - ""
+  Organization: Production
+  Parent: Manufacturing
+- DepartmentName: PLANNING
+  SubList:
+  - ""
+  Organization: Manufacturing
+`
+
+func orgParents(t *testing.T, client *itopclient.FakeClient) map[string]string {
+	t.Helper()
+	orgs, err := client.GetOrganizations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents := make(map[string]string)
+	for _, o := range orgs {
+		parents[o.Name] = o.ParentID
+	}
+	return parents
+}
+
+// newHierarchyFixture seeds Production and Manufacturing side by side under the
+// root organization, while the department list puts Production under Manufacturing
+func newHierarchyFixture(t *testing.T) (string, *itopclient.FakeClient) {
+	yamlPath := writeFile(t, t.TempDir(), "departments.yaml", hierarchyYAML)
+	client := itopclient.NewFakeClient()
+	client.AddOrganization("1", "Root", "")
+	client.AddOrganization("2", "Manufacturing", "1")
+	client.AddOrganization("3", "Production", "1")
+	return yamlPath, client
+}
+
+func TestSyncTeamsToItopReparentsOrganization(t *testing.T) {
+	yamlPath, client := newHierarchyFixture(t)
+
+	if err := SyncTeamsToItop(yamlPath, client, "1", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	parents := orgParents(t, client)
+	if parents["Production"] != "2" || parents["Manufacturing"] != "1" {
+		t.Errorf("parents = %v, want Production under 2 and Manufacturing under 1", parents)
+	}
+	depts := readDepartments(t, yamlPath)
+	if depts["SMT"].OrganizationID != "3" {
+		t.Errorf("SMT OrganizationID = %q, want the existing Production (3)", depts["SMT"].OrganizationID)
+	}
+
+	// The cached OrganizationID is checked too, and nothing moves again
+	updates := client.Updates
+	if err := SyncTeamsToItop(yamlPath, client, "1", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.Updates != updates {
+		t.Errorf("second run made %d update(s), want none", client.Updates-updates)
+	}
+}
+
+func TestSyncTeamsToItopReparentsOrganizationDryRun(t *testing.T) {
+	yamlPath, client := newHierarchyFixture(t)
+
+	plan := NewPlan()
+	if err := SyncTeamsToItop(yamlPath, client, "1", true, plan); err != nil {
+		t.Fatal(err)
+	}
+	if parents := orgParents(t, client); parents["Production"] != "1" {
+		t.Errorf("dry run moved Production under %s", parents["Production"])
+	}
+	if len(plan.OrgMoves) != 1 || plan.OrgMoves[0].OrgID != "3" || plan.OrgMoves[0].NewParentID != "2" {
+		t.Errorf("OrgMoves = %+v, want Production (3) under 2", plan.OrgMoves)
+	}
+	if len(plan.OrgsToCreate) != 0 {
+		t.Errorf("OrgsToCreate = %+v, want none", plan.OrgsToCreate)
+	}
+}
+
+func TestSyncTeamsToItopKeepsUndeclaredParent(t *testing.T) {
+	// Subsidiary is a source organization under Holding, not under the root; the
+	// department list names it without a Parent
+	yamlPath := writeFile(t, t.TempDir(), "departments.yaml", `- DepartmentName: SALES
+  SubList:
+  - ""
+  Organization: Subsidiary
+`)
+	client := itopclient.NewFakeClient()
+	client.AddOrganization("1", "Root", "")
+	client.AddOrganization("5", "Holding", "")
+	client.AddOrganization("6", "Subsidiary", "5")
+
+	if err := SyncTeamsToItop(yamlPath, client, "1", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if parents := orgParents(t, client); parents["Subsidiary"] != "5" {
+		t.Errorf("Subsidiary moved under %s, want it kept under Holding (5)", parents["Subsidiary"])
+	}
+}
+
+func TestOrgResolverRefusesCycle(t *testing.T) {
+	// Manufacturing sits below Production in iTop; the list puts Production under
+	// Manufacturing, and Production under itself through a descendant
+	client := itopclient.NewFakeClient()
+	client.AddOrganization("1", "Root", "")
+	client.AddOrganization("3", "Production", "1")
+	client.AddOrganization("2", "Manufacturing", "3")
+	deptList := DepartmentYAMLList{
+		{DepartmentName: "SMT", Organization: "Production", Parent: "Manufacturing"},
+		{DepartmentName: "PLANNING", Organization: "Manufacturing"},
+	}
+
+	for _, plan := range []*Plan{nil, NewPlan()} {
+		r, err := newOrgResolver(client, deptList, "1", plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := r.resolve("Production")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "3" {
+			t.Errorf("Production resolved to %s, want 3", id)
+		}
+		if len(r.refused) != 1 || r.refused[0] != "Production" {
+			t.Errorf("refused = %v, want Production", r.refused)
+		}
+		if plan != nil && len(plan.OrgMoves) != 0 {
+			t.Errorf("OrgMoves = %+v, want none", plan.OrgMoves)
+		}
+	}
+	if parents := orgParents(t, client); parents["Production"] != "1" || parents["Manufacturing"] != "3" || client.Updates != 0 {
+		t.Errorf("parents = %v after %d update(s), want the hierarchy untouched", parents, client.Updates)
+	}
+}
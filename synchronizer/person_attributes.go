@@ -0,0 +1,132 @@
+package synchronizer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	itopclient "ldap-itop/itopclient"
+	"ldap-itop/parser"
+)
+
+// PersonFieldMapping copies one LDAP attribute into one iTop Person field
+type PersonFieldMapping struct {
+	LDAPAttribute string
+	ITopField     string
+}
+
+// DefaultPersonFieldMap is used when no mapping is configured. The AD manager is a
+// DN that has to be resolved to a Person first, so it is not part of this mapping.
+const DefaultPersonFieldMap = "mail=email;telephoneNumber=phone;mobile=mobile_phone;title=function;employeeID=employee_number"
+
+// ParsePersonFieldMap parses a spec like "mail=email;title=function"
+func ParsePersonFieldMap(spec string) ([]PersonFieldMapping, error) {
+	var mappings []PersonFieldMapping
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		attr, field, ok := strings.Cut(pair, "=")
+		attr, field = strings.TrimSpace(attr), strings.TrimSpace(field)
+		if !ok || attr == "" || field == "" {
+			return nil, fmt.Errorf("invalid Person field mapping %q, expected ldapAttribute=itopField", pair)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("iTop field %q is mapped more than once", field)
+		}
+		seen[field] = true
+		mappings = append(mappings, PersonFieldMapping{LDAPAttribute: attr, ITopField: field})
+	}
+	return mappings, nil
+}
+
+// LDAPAttributes returns the LDAP attributes a mapping reads
+func LDAPAttributes(mappings []PersonFieldMapping) []string {
+	attrs := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		attrs = append(attrs, m.LDAPAttribute)
+	}
+	return attrs
+}
+
+// SyncPersonAttributes updates the Person linked to each user's iTop login when a
+// mapped field differs from AD. Empty AD values never clear a field in iTop. Every
+// difference is written to reportCSV; when plan is non-nil nothing is updated.
+// Users whose CN is in exclude are skipped.
+func SyncPersonAttributes(users []parser.User, client itopclient.API, mappings []PersonFieldMapping, exclude []string, reportCSV string, plan *Plan) error {
+	excludeMap := excludeSet(exclude)
+
+	reportF, err := os.Create(reportCSV)
+	if err != nil {
+		return err
+	}
+	defer reportF.Close()
+	reportW := csv.NewWriter(reportF)
+	defer reportW.Flush()
+	reportW.Write([]string{"nama", "sAMAccountName", "person_id", "field", "itop_value", "ad_value", "status"})
+
+	fields := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		fields = append(fields, m.ITopField)
+	}
+
+	contactIDs, err := client.GetUserContactIDs()
+	if err != nil {
+		return fmt.Errorf("failed to read iTop users: %w", err)
+	}
+
+	for _, user := range users {
+		if excludeMap[user.CN] || user.SAMAccountName == "" {
+			continue
+		}
+		personID := contactIDs[strings.ToLower(user.SAMAccountName)]
+		if personID == "" {
+			continue
+		}
+		current, err := client.GetPerson(personID, fields)
+		if itopclient.IsUnavailable(err) {
+			return fmt.Errorf("failed to read Person::%s: %w", personID, err)
+		}
+		if err != nil {
+			reportW.Write([]string{user.CN, user.SAMAccountName, personID, "", "", "", "Failed to read Person: " + err.Error()})
+			continue
+		}
+		changes := make(map[string]string)
+		for _, m := range mappings {
+			adValue := strings.TrimSpace(user.Attributes[m.LDAPAttribute])
+			if adValue == "" || adValue == current[m.ITopField] {
+				continue
+			}
+			changes[m.ITopField] = adValue
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		changed := make([]string, 0, len(changes))
+		for f := range changes {
+			changed = append(changed, f)
+		}
+		sort.Strings(changed)
+
+		status := "Updated"
+		if plan != nil {
+			for _, f := range changed {
+				plan.updatePerson(PlannedPersonUpdate{PersonID: personID, SAMAccountName: user.SAMAccountName, Field: f, OldValue: current[f], NewValue: changes[f]})
+			}
+			status = "Would be updated (dry-run)"
+		} else if err := client.UpdatePerson(personID, changes, fmt.Sprintf("Sinkronisasi atribut %s dari AD", strings.Join(changed, ", "))); err != nil {
+			status = "Failed to update Person: " + err.Error()
+		} else {
+			log.Printf("[OK] Updated Person::%s (%s): %s", personID, user.CN, strings.Join(changed, ", "))
+		}
+		for _, f := range changed {
+			reportW.Write([]string{user.CN, user.SAMAccountName, personID, f, current[f], changes[f], status})
+		}
+	}
+	return nil
+}
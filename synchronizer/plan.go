@@ -0,0 +1,194 @@
+package synchronizer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	itopclient "ldap-itop/itopclient"
+)
+
+// plannedTeamPrefix marks a TeamID that only exists in a dry-run plan
+const plannedTeamPrefix = "new:"
+
+// plannedOrgPrefix marks an Organization id that only exists in a dry-run plan
+const plannedOrgPrefix = "new-org:"
+
+// plannedPersonPrefix marks a Person id that only exists in a dry-run plan
+const plannedPersonPrefix = "new-person:"
+
+// Plan collects the changes a dry run would have applied to iTop.
+// Passing a nil *Plan to the sync functions applies changes for real.
+type Plan struct {
+	OrgsToCreate        []PlannedOrganization
+	OrgMoves            []PlannedOrgMove
+	TeamsToCreate       []PlannedTeam
+	TeamIDRewrites      []PlannedTeamIDRewrite
+	TeamMoves           []PlannedTeamMove
+	MembershipsToAdd    []PlannedMembership
+	MembershipsToRemove []PlannedMembership
+	PersonsToCreate     []PlannedPerson
+	UsersToCreate       []PlannedUserAccount
+	PersonUpdates       []PlannedPersonUpdate
+
+	teamIDs map[string]string // DepartmentName -> planned TeamID
+}
+
+type PlannedOrganization struct {
+	Name     string
+	ParentID string
+}
+
+type PlannedOrgMove struct {
+	Name        string
+	OrgID       string
+	OldParentID string
+	NewParentID string
+}
+
+type PlannedTeamMove struct {
+	DepartmentName string
+	TeamID         string
+	OldOrgID       string
+	NewOrgID       string
+}
+
+type PlannedTeam struct {
+	DepartmentName string
+	OrgID          string
+}
+
+type PlannedTeamIDRewrite struct {
+	DepartmentName string
+	OldTeamID      string
+	NewTeamID      string
+}
+
+type PlannedMembership struct {
+	CN             string
+	Email          string
+	SAMAccountName string
+	PersonID       string
+	TeamID         string
+	DepartmentName string
+}
+
+type PlannedPerson struct {
+	Person         itopclient.Person
+	SAMAccountName string
+}
+
+type PlannedUserAccount struct {
+	Login    string
+	PersonID string
+	Profile  string
+}
+
+type PlannedPersonUpdate struct {
+	PersonID       string
+	SAMAccountName string
+	Field          string
+	OldValue       string
+	NewValue       string
+}
+
+func NewPlan() *Plan {
+	return &Plan{teamIDs: make(map[string]string)}
+}
+
+func (p *Plan) addOrganization(name, parentID string) string {
+	p.OrgsToCreate = append(p.OrgsToCreate, PlannedOrganization{Name: name, ParentID: parentID})
+	return plannedOrgPrefix + name
+}
+
+func (p *Plan) moveOrganization(m PlannedOrgMove) {
+	p.OrgMoves = append(p.OrgMoves, m)
+}
+
+func (p *Plan) moveTeam(m PlannedTeamMove) {
+	p.TeamMoves = append(p.TeamMoves, m)
+}
+
+func (p *Plan) addTeam(deptName, orgID string) string {
+	p.TeamsToCreate = append(p.TeamsToCreate, PlannedTeam{DepartmentName: deptName, OrgID: orgID})
+	teamID := plannedTeamPrefix + deptName
+	p.teamIDs[deptName] = teamID
+	return teamID
+}
+
+func (p *Plan) rewriteTeamID(deptName, oldID, newID string) {
+	p.TeamIDRewrites = append(p.TeamIDRewrites, PlannedTeamIDRewrite{DepartmentName: deptName, OldTeamID: oldID, NewTeamID: newID})
+	p.teamIDs[deptName] = newID
+}
+
+func (p *Plan) addMembership(m PlannedMembership) {
+	p.MembershipsToAdd = append(p.MembershipsToAdd, m)
+}
+
+func (p *Plan) removeMembership(m PlannedMembership) {
+	p.MembershipsToRemove = append(p.MembershipsToRemove, m)
+}
+
+func (p *Plan) addPerson(person itopclient.Person, login string) string {
+	p.PersonsToCreate = append(p.PersonsToCreate, PlannedPerson{Person: person, SAMAccountName: login})
+	return plannedPersonPrefix + login
+}
+
+func (p *Plan) addUserAccount(login, personID, profile string) {
+	p.UsersToCreate = append(p.UsersToCreate, PlannedUserAccount{Login: login, PersonID: personID, Profile: profile})
+}
+
+func (p *Plan) updatePerson(u PlannedPersonUpdate) {
+	p.PersonUpdates = append(p.PersonUpdates, u)
+}
+
+func isPlannedTeamID(teamID string) bool {
+	return strings.HasPrefix(teamID, plannedTeamPrefix)
+}
+
+// WriteReport writes every planned change to a CSV file, one row per action
+func (p *Plan) WriteReport(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"Action", "Department", "Team-ID", "Previous-Team-ID", "Person-ID", "CN", "Email", "SAMAccountName", "Detail"}); err != nil {
+		return err
+	}
+	for _, o := range p.OrgsToCreate {
+		w.Write([]string{"create-organization", "", "", "", "", "", "", "", fmt.Sprintf("%s, parent_id %s", o.Name, o.ParentID)})
+	}
+	for _, m := range p.OrgMoves {
+		w.Write([]string{"move-organization", "", "", "", "", "", "", "", fmt.Sprintf("%s (%s), parent_id %s -> %s", m.Name, m.OrgID, m.OldParentID, m.NewParentID)})
+	}
+	for _, t := range p.TeamsToCreate {
+		w.Write([]string{"create-team", t.DepartmentName, "", "", "", "", "", "", "org_id " + t.OrgID})
+	}
+	for _, r := range p.TeamIDRewrites {
+		w.Write([]string{"rewrite-team-id", r.DepartmentName, r.NewTeamID, r.OldTeamID, "", "", "", "", ""})
+	}
+	for _, m := range p.TeamMoves {
+		w.Write([]string{"move-team", m.DepartmentName, m.TeamID, "", "", "", "", "", fmt.Sprintf("org_id %s -> %s", m.OldOrgID, m.NewOrgID)})
+	}
+	for _, pp := range p.PersonsToCreate {
+		name := strings.TrimSpace(pp.Person.FirstName + " " + pp.Person.Name)
+		w.Write([]string{"create-person", "", "", "", plannedPersonPrefix + pp.SAMAccountName, name, pp.Person.Email, pp.SAMAccountName, "org_id " + pp.Person.OrgID})
+	}
+	for _, u := range p.UsersToCreate {
+		w.Write([]string{"create-user-ldap", "", "", "", u.PersonID, "", "", u.Login, "profile " + u.Profile})
+	}
+	for _, m := range p.MembershipsToAdd {
+		w.Write([]string{"add-member", m.DepartmentName, m.TeamID, "", m.PersonID, m.CN, m.Email, m.SAMAccountName, ""})
+	}
+	for _, m := range p.MembershipsToRemove {
+		w.Write([]string{"remove-member", m.DepartmentName, m.TeamID, "", m.PersonID, m.CN, "", "", ""})
+	}
+	for _, u := range p.PersonUpdates {
+		w.Write([]string{"update-person", "", "", "", u.PersonID, "", "", u.SAMAccountName, fmt.Sprintf("%s: %q -> %q", u.Field, u.OldValue, u.NewValue)})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,92 @@
+package synchronizer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	itopclient "ldap-itop/itopclient"
+)
+
+// ProvisionOptions controls creation of missing iTop Persons and Users
+type ProvisionOptions struct {
+	// Persons creates a Person for AD users that have no iTop User
+	Persons bool
+	// UserAccounts also creates a UserLDAP account for the Person
+	UserAccounts bool
+	// Profile is the profile given to created UserLDAP accounts
+	Profile string
+	// DefaultOrgID is used for users whose LDAP source has no iTop organization
+	DefaultOrgID string
+	// ReportCSV is the report of provisioned users
+	ReportCSV string
+}
+
+// errNoStableKey is returned for a user whose Person could not be found again on
+// the next run, so creating it would create a duplicate every run
+var errNoStableKey = errors.New("no email to find the Person again, and no UserLDAP account is created")
+
+// provisionUser finds or creates the Person for a user that has no iTop User yet and,
+// when enabled, creates its UserLDAP account. It returns the Person id and a status
+// for the report. An existing Person with the same email is reused, so a user that
+// only got a Person on an earlier run is not created twice. A user without email is
+// only provisioned together with its UserLDAP account, which links it by login.
+func provisionUser(client itopclient.API, user UserCSV, opts ProvisionOptions, plan *Plan) (string, string, error) {
+	if user.Email == "" && (!opts.UserAccounts || user.SAMAccountName == "") {
+		return "", "", errNoStableKey
+	}
+	personID := ""
+	status := ""
+	if user.Email != "" {
+		id, err := client.FindPersonByEmail(user.Email)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to look up Person by email: %w", err)
+		}
+		personID = id
+	}
+	if personID != "" {
+		status = "Existing Person linked by email"
+	} else {
+		person := itopclient.Person{
+			Name:      user.LastName,
+			FirstName: user.FirstName,
+			Email:     user.Email,
+			Phone:     user.Phone,
+			OrgID:     user.OrgID,
+		}
+		if person.Name == "" {
+			// Accounts without a surname (service or shared mailboxes) keep their full CN
+			person.Name = user.CN
+			person.FirstName = ""
+		}
+		if person.OrgID == "" {
+			person.OrgID = opts.DefaultOrgID
+		}
+		if plan != nil {
+			personID = plan.addPerson(person, user.SAMAccountName)
+			status = "Would create Person (dry-run)"
+		} else {
+			id, err := client.CreatePerson(person, fmt.Sprintf("Provisioning %s dari AD", user.SAMAccountName))
+			if err != nil {
+				return "", "", fmt.Errorf("failed to create Person: %w", err)
+			}
+			personID = id
+			status = "Person created"
+			log.Printf("[OK] Created Person::%s for '%s'", personID, user.CN)
+		}
+	}
+
+	if !opts.UserAccounts || user.SAMAccountName == "" {
+		return personID, status, nil
+	}
+	if plan != nil {
+		plan.addUserAccount(user.SAMAccountName, personID, opts.Profile)
+		return personID, status + ", would create UserLDAP (dry-run)", nil
+	}
+	if _, err := client.CreateUserLDAP(user.SAMAccountName, personID, opts.Profile, fmt.Sprintf("Provisioning %s dari AD", user.SAMAccountName)); err != nil {
+		// The Person exists, so the user can still be added to the team
+		return personID, status + ", failed to create UserLDAP: " + err.Error(), nil
+	}
+	log.Printf("[OK] Created UserLDAP '%s' for Person::%s", user.SAMAccountName, personID)
+	return personID, status + ", UserLDAP created", nil
+}
@@ -0,0 +1,58 @@
+package synchronizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	itopclient "ldap-itop/itopclient"
+)
+
+func TestProvisionWithoutEmail(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := writeFile(t, dir, "departments.yaml", usersYAML)
+	csvPath := writeFile(t, dir, "users.csv", `CN,Email,SAMAccountName,Department,Valid-Department,First-Name,Last-Name
+Erin,,erin,Digi,DIGI,Erin,Doe
+`)
+	notSynced := filepath.Join(dir, "not-synced.csv")
+	opts := UserSyncOptions{
+		MaxRemovals: -1,
+		SyncedCSV:   filepath.Join(dir, "synced.csv"),
+		Provision: ProvisionOptions{
+			Persons:      true,
+			DefaultOrgID: "1",
+			ReportCSV:    filepath.Join(dir, "provisioned.csv"),
+		},
+	}
+
+	// Without a UserLDAP account the Person could not be found again next run
+	client := itopclient.NewFakeClient()
+	client.AddTeam("10", "DIGI")
+	for run := 0; run < 3; run++ {
+		if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.Creates != 0 || len(client.Members("10")) != 0 {
+		t.Errorf("creates = %d, members = %s, want nothing provisioned", client.Creates, memberIDs(client.Members("10")))
+	}
+	report, _ := os.ReadFile(notSynced)
+	if !strings.Contains(string(report), "not provisioned") {
+		t.Errorf("Erin should be reported as not provisioned:\n%s", report)
+	}
+
+	// With a UserLDAP account the login links the Person on later runs
+	opts.Provision.UserAccounts = true
+	opts.Provision.Profile = "Portal user"
+	client = itopclient.NewFakeClient()
+	client.AddTeam("10", "DIGI")
+	for run := 0; run < 3; run++ {
+		if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.Creates != 2 || len(client.Members("10")) != 1 {
+		t.Errorf("creates = %d, members = %s, want one Person and one UserLDAP", client.Creates, memberIDs(client.Members("10")))
+	}
+}
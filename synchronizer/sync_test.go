@@ -0,0 +1,228 @@
+package synchronizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	itopclient "ldap-itop/itopclient"
+
+	"gopkg.in/yaml.v2"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readDepartments(t *testing.T, path string) map[string]DepartmentYAML {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list DepartmentYAMLList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		t.Fatal(err)
+	}
+	depts := make(map[string]DepartmentYAML)
+	for _, d := range list {
+		depts[d.DepartmentName] = d
+	}
+	return depts
+}
+
+func memberIDs(members []itopclient.TeamMember) string {
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.PersonID)
+	}
+	return strings.Join(ids, ",")
+}
+
+const teamsYAML = `- DepartmentName: DIGI
+  SubList:
+  - Digi
+  TeamID: "999"
+- DepartmentName: FINANCE
+  SubList:
+  - ""
+`
+
+func TestSyncTeamsToItop(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := writeFile(t, dir, "departments.yaml", teamsYAML)
+	client := itopclient.NewFakeClient()
+	client.AddTeam("10", "DIGI")
+
+	if err := SyncTeamsToItop(yamlPath, client, "1", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	depts := readDepartments(t, yamlPath)
+	// The stale TeamID is rewritten to the Team found by name
+	if got := depts["DIGI"].TeamID; got != "10" {
+		t.Errorf("DIGI TeamID = %q, want 10", got)
+	}
+	// The missing Team is created and recorded
+	if depts["FINANCE"].TeamID == "" || client.Creates != 1 {
+		t.Errorf("FINANCE TeamID = %q after %d create(s), want a created team", depts["FINANCE"].TeamID, client.Creates)
+	}
+
+	// A second run has nothing left to do
+	if err := SyncTeamsToItop(yamlPath, client, "1", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.Creates != 1 {
+		t.Errorf("second run created %d team(s), want none", client.Creates-1)
+	}
+}
+
+func TestSyncTeamsToItopDryRun(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := writeFile(t, dir, "departments.yaml", teamsYAML)
+	client := itopclient.NewFakeClient()
+	client.AddTeam("10", "DIGI")
+
+	plan := NewPlan()
+	if err := SyncTeamsToItop(yamlPath, client, "1", false, plan); err != nil {
+		t.Fatal(err)
+	}
+	if client.Creates != 0 {
+		t.Errorf("dry run created %d team(s)", client.Creates)
+	}
+	data, _ := os.ReadFile(yamlPath)
+	if string(data) != teamsYAML {
+		t.Errorf("dry run rewrote the YAML:\n%s", data)
+	}
+	if len(plan.TeamsToCreate) != 1 || plan.TeamsToCreate[0].DepartmentName != "FINANCE" {
+		t.Errorf("TeamsToCreate = %+v, want FINANCE", plan.TeamsToCreate)
+	}
+	if len(plan.TeamIDRewrites) != 1 || plan.TeamIDRewrites[0].NewTeamID != "10" {
+		t.Errorf("TeamIDRewrites = %+v, want DIGI -> 10", plan.TeamIDRewrites)
+	}
+}
+
+const usersYAML = `- DepartmentName: DIGI
+  SubList:
+  - Digi
+  TeamID: "10"
+- DepartmentName: FINANCE
+  SubList:
+  - ""
+  TeamID: "20"
+`
+
+const usersCSV = `CN,Email,SAMAccountName,Department,Valid-Department
+Alice,alice@example.com,alice,Digi,DIGI
+Bob,bob@example.com,bob,Finance,FINANCE
+Carol,carol@example.com,carol,Nowhere,NOWHERE
+Dave,dave@example.com,dave,Finance,FINANCE
+`
+
+// newUserSyncFixture seeds two teams: DIGI already has Dave (who moved to FINANCE
+// in AD) and Erin (whose AD department failed validation, so she is not in the
+// CSV), FINANCE is empty. Alice, Bob, Dave and Erin have iTop Users.
+func newUserSyncFixture(t *testing.T) (string, string, *itopclient.FakeClient, UserSyncOptions) {
+	dir := t.TempDir()
+	yamlPath := writeFile(t, dir, "departments.yaml", usersYAML)
+	csvPath := writeFile(t, dir, "users.csv", usersCSV)
+	client := itopclient.NewFakeClient()
+	client.AddTeam("10", "DIGI", itopclient.TeamMember{PersonID: "4", RoleID: "0"}, itopclient.TeamMember{PersonID: "5", RoleID: "0"})
+	client.AddTeam("20", "FINANCE")
+	client.AddUser("alice", "1")
+	client.AddUser("bob", "2")
+	client.AddUser("dave", "4")
+	client.AddUser("erin", "5")
+	opts := UserSyncOptions{
+		MaxRemovals: -1,
+		RemovedCSV:  filepath.Join(dir, "removed.csv"),
+		SyncedCSV:   filepath.Join(dir, "synced.csv"),
+		Workers:     2,
+	}
+	return yamlPath, csvPath, client, opts
+}
+
+func TestSyncUsersToTeams(t *testing.T) {
+	yamlPath, csvPath, client, opts := newUserSyncFixture(t)
+	notSynced := filepath.Join(filepath.Dir(csvPath), "not-synced.csv")
+
+	if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := memberIDs(client.Members("10")); got != "4,5,1" {
+		t.Errorf("DIGI members = %s, want 4,5,1", got)
+	}
+	if got := memberIDs(client.Members("20")); got != "2,4" {
+		t.Errorf("FINANCE members = %s, want 2,4", got)
+	}
+	report, _ := os.ReadFile(notSynced)
+	if !strings.Contains(string(report), "Carol") {
+		t.Errorf("Carol has no team and should be reported:\n%s", report)
+	}
+
+	// Adding again is a no-op
+	updates := client.Updates
+	if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if client.Updates != updates {
+		t.Errorf("second run made %d update(s), want none", client.Updates-updates)
+	}
+}
+
+func TestSyncUsersToTeamsReconcile(t *testing.T) {
+	yamlPath, csvPath, client, opts := newUserSyncFixture(t)
+	notSynced := filepath.Join(filepath.Dir(csvPath), "not-synced.csv")
+	opts.Reconcile = true
+
+	if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Dave moved to FINANCE and leaves DIGI; Erin has no team from AD and stays
+	if got := memberIDs(client.Members("10")); got != "5,1" {
+		t.Errorf("DIGI members = %s, want Erin and Alice (5,1)", got)
+	}
+	if got := memberIDs(client.Members("20")); got != "2,4" {
+		t.Errorf("FINANCE members = %s, want 2,4", got)
+	}
+	removed, _ := os.ReadFile(opts.RemovedCSV)
+	if !strings.Contains(string(removed), "10,DIGI,4,,Removed from team") || !strings.Contains(string(removed), `10,DIGI,5,,"Kept:`) {
+		t.Errorf("removed report should list Dave as removed and Erin as kept:\n%s", removed)
+	}
+
+	// Above MaxRemovals nothing is removed
+	yamlPath, csvPath, client, opts = newUserSyncFixture(t)
+	opts.Reconcile = true
+	opts.MaxRemovals = 0
+	if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := memberIDs(client.Members("10")); got != "4,5,1" {
+		t.Errorf("DIGI members = %s, want 4,5,1 with removals capped", got)
+	}
+}
+
+func TestSyncUsersToTeamsDryRun(t *testing.T) {
+	yamlPath, csvPath, client, opts := newUserSyncFixture(t)
+	notSynced := filepath.Join(filepath.Dir(csvPath), "not-synced.csv")
+	opts.Reconcile = true
+
+	plan := NewPlan()
+	if err := SyncUsersToTeams(csvPath, yamlPath, notSynced, client, opts, plan); err != nil {
+		t.Fatal(err)
+	}
+	if client.Updates != 0 {
+		t.Errorf("dry run made %d update(s)", client.Updates)
+	}
+	if len(plan.MembershipsToAdd) != 3 {
+		t.Errorf("MembershipsToAdd = %+v, want Alice, Bob and Dave", plan.MembershipsToAdd)
+	}
+	if len(plan.MembershipsToRemove) != 1 || plan.MembershipsToRemove[0].PersonID != "4" {
+		t.Errorf("MembershipsToRemove = %+v, want Dave (4)", plan.MembershipsToRemove)
+	}
+}
@@ -2,14 +2,16 @@ package synchronizer
 
 import (
 	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	itopclient "ldap-itop/itopclient"
+	"ldap-itop/metrics"
 
 	"gopkg.in/yaml.v2"
 )
@@ -20,6 +22,11 @@ type UserCSV struct {
 	SAMAccountName  string
 	Department      string
 	ValidDepartment string
+	Source          string
+	OrgID           string
+	FirstName       string
+	LastName        string
+	Phone           string
 }
 
 type TeamYAML struct {
@@ -29,19 +36,29 @@ type TeamYAML struct {
 
 type TeamYAMLList []TeamYAML
 
-func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclient.ITopClient) error {
-	// Ambil exclude list dari env var
-	excludeRaw := os.Getenv("EXCLUDE_LIST")
-	excludeMap := make(map[string]bool)
-	if excludeRaw != "" {
-		excludeArr := strings.Split(excludeRaw, ";")
-		for _, cn := range excludeArr {
-			trimmed := strings.TrimSpace(cn)
-			if trimmed != "" {
-				excludeMap[trimmed] = true
-			}
-		}
-	}
+// UserSyncOptions controls optional behaviour of SyncUsersToTeams
+type UserSyncOptions struct {
+	// Reconcile removes Persons from managed teams when their AD department moved them to another team
+	Reconcile bool
+	// MaxRemovals aborts all removals of a run when more would be removed; negative means no limit
+	MaxRemovals int
+	// RemovedCSV is the report of removed (or skipped) memberships
+	RemovedCSV string
+	// Provision creates missing Persons (and optionally Users) instead of reporting them
+	Provision ProvisionOptions
+	// Workers is the number of Teams updated in parallel; each Team is only ever
+	// updated by one worker at a time
+	Workers int
+	// SyncedCSV is the report of users already in or added to their team
+	SyncedCSV string
+	// Exclude lists the CNs that are never synchronised
+	Exclude []string
+}
+
+// SyncUsersToTeams adds every user in usersCSV to the Team of its valid department.
+// When plan is non-nil no Team is updated; planned memberships are recorded instead.
+func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client itopclient.API, opts UserSyncOptions, plan *Plan) error {
+	excludeMap := excludeSet(opts.Exclude)
 	// Load users.csv
 	f, err := os.Open(usersCSV)
 	if err != nil {
@@ -66,12 +83,24 @@ func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclien
 		if err != nil {
 			return err
 		}
+		// Columns added in later versions may be missing from older or hand-edited files
+		field := func(name string) string {
+			if i, ok := colIdx[name]; ok && i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
 		users = append(users, UserCSV{
 			CN:              rec[colIdx["CN"]],
 			Email:           rec[colIdx["Email"]],
 			SAMAccountName:  rec[colIdx["SAMAccountName"]],
 			Department:      rec[colIdx["Department"]],
 			ValidDepartment: rec[colIdx["Valid-Department"]],
+			Source:          field("Source"),
+			OrgID:           field("Org-ID"),
+			FirstName:       field("First-Name"),
+			LastName:        field("Last-Name"),
+			Phone:           field("Phone"),
 		})
 	}
 
@@ -96,6 +125,18 @@ func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclien
 			teamMap[t.DepartmentName] = teamInfo{TeamID: t.TeamID, DeptName: t.DepartmentName}
 		}
 	}
+	// On a dry run the YAML was not rewritten, so use the TeamIDs from the plan
+	if plan != nil {
+		for deptName, teamID := range plan.teamIDs {
+			teamMap[deptName] = teamInfo{TeamID: teamID, DeptName: deptName}
+		}
+	}
+
+	// desired holds, per TeamID, the Person ids that belong to the team according to AD
+	var desired map[string]map[string]bool
+	if opts.Reconcile {
+		desired = make(map[string]map[string]bool)
+	}
 
 	// Prepare not-synced CSV
 	notSyncedF, err := os.Create(notSyncedCSV)
@@ -108,8 +149,7 @@ func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclien
 	notSyncedW.Write([]string{"nama", "email", "sAMAccountName", "status"})
 
 	// Prepare successfully-synced CSV
-	successSyncedCSV := "output/user-successfully-sync.csv"
-	successSyncedF, err := os.Create(successSyncedCSV)
+	successSyncedF, err := os.Create(opts.SyncedCSV)
 	if err != nil {
 		return err
 	}
@@ -118,6 +158,43 @@ func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclien
 	defer successSyncedW.Flush()
 	successSyncedW.Write([]string{"nama", "email", "team_id", "status"})
 
+	var provisionedW *csv.Writer
+	if opts.Provision.Persons {
+		provisionedF, err := os.Create(opts.Provision.ReportCSV)
+		if err != nil {
+			return err
+		}
+		defer provisionedF.Close()
+		provisionedW = csv.NewWriter(provisionedF)
+		defer provisionedW.Flush()
+		provisionedW.Write([]string{"nama", "email", "sAMAccountName", "person_id", "status"})
+	}
+
+	// Prefetch every User login and the members of every managed Team, so the loop
+	// below works in memory instead of issuing two core/get calls per user
+	contactIDs, err := client.GetUserContactIDs()
+	if err != nil {
+		return fmt.Errorf("failed to read iTop users: %w", err)
+	}
+	var teamIDs []string
+	for _, t := range teamMap {
+		if !isPlannedTeamID(t.TeamID) {
+			teamIDs = append(teamIDs, t.TeamID)
+		}
+	}
+	teamMembers, err := client.GetTeamsMembers(teamIDs)
+	if err != nil {
+		return fmt.Errorf("failed to read iTop team members: %w", err)
+	}
+
+	// pendingAdd is a user waiting to be added to a team in that team's single update
+	type pendingAdd struct {
+		user     UserCSV
+		personID string
+	}
+	additions := make(map[string][]pendingAdd) // TeamID -> users to add
+	teamNames := make(map[string]string)       // TeamID -> DepartmentName
+
 	for _, user := range users {
 		if excludeMap[user.CN] {
 			log.Printf("[SKIP] User '%s' di-exclude dari sinkronisasi.", user.CN)
@@ -129,132 +206,141 @@ func SyncUsersToTeams(usersCSV, yamlPath, notSyncedCSV string, client *itopclien
 			notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "No TeamID mapping for department: " + user.ValidDepartment})
 			continue
 		}
-		userID := ""
-		if user.SAMAccountName != "" {
-			resp, err := client.Post("core/get", map[string]interface{}{
-				"class":         "User",
-				"key":           fmt.Sprintf("SELECT User WHERE login=\"%s\"", user.SAMAccountName),
-				"output_fields": "contactid,login,email",
-			})
-			if err == nil && resp != nil {
-				var respMap map[string]interface{}
-				if err := json.Unmarshal(resp, &respMap); err == nil {
-					objsRaw, ok := respMap["objects"]
-					if ok {
-						if objs, ok := objsRaw.(map[string]interface{}); ok && len(objs) > 0 {
-							for _, v := range objs {
-								if obj, ok := v.(map[string]interface{}); ok {
-									if fields, ok := obj["fields"].(map[string]interface{}); ok {
-										switch idVal := fields["contactid"].(type) {
-										case string:
-											userID = idVal
-										case float64:
-											userID = fmt.Sprintf("%.0f", idVal)
-										}
-									}
-								}
-							}
-						}
-					}
-				}
+		userID := contactIDs[strings.ToLower(user.SAMAccountName)]
+		if userID == "" && opts.Provision.Persons {
+			personID, status, err := provisionUser(client, user, opts.Provision, plan)
+			if itopclient.IsUnavailable(err) {
+				return fmt.Errorf("provisioning '%s': %w", user.CN, err)
+			}
+			if errors.Is(err, errNoStableKey) {
+				provisionedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "", "Skipped: " + err.Error()})
+				notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "User not found in iTop (by login), not provisioned: " + err.Error()})
+				continue
 			}
+			if err != nil {
+				provisionedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "", "Failed: " + err.Error()})
+				notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "Provisioning failed: " + err.Error()})
+				continue
+			}
+			provisionedW.Write([]string{user.CN, user.Email, user.SAMAccountName, personID, status})
+			userID = personID
 		}
 		if userID == "" {
 			notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "User not found in iTop (by login)"})
 			continue
 		}
-		resp, err := client.Post("core/get", map[string]interface{}{
-			"class":         "Team",
-			"key":           team.TeamID,
-			"output_fields": "persons_list",
-		})
-		personsList := []map[string]interface{}{}
-		if err == nil && resp != nil {
-			var respMap map[string]interface{}
-			if err := json.Unmarshal(resp, &respMap); err == nil {
-				teamKey := fmt.Sprintf("Team::%s", team.TeamID)
-				if obj, ok := respMap["objects"].(map[string]interface{}); ok {
-					if teamObj, ok := obj[teamKey].(map[string]interface{}); ok {
-						if fields, ok := teamObj["fields"].(map[string]interface{}); ok {
-							if pl, ok := fields["persons_list"].([]interface{}); ok {
-								for _, p := range pl {
-									if pm, ok := p.(map[string]interface{}); ok {
-										entry := map[string]interface{}{
-											"person_id": pm["person_id"],
-											"role_id":   pm["role_id"],
-										}
-										personsList = append(personsList, entry)
-									}
-								}
-							}
-						}
-					}
-				}
+		if desired != nil {
+			if desired[team.TeamID] == nil {
+				desired[team.TeamID] = make(map[string]bool)
 			}
+			desired[team.TeamID][userID] = true
 		}
-		alreadyInTeam := false
-		for _, p := range personsList {
-			if fmt.Sprintf("%v", p["person_id"]) == userID {
-				alreadyInTeam = true
-				break
-			}
+		members, found := teamMembers[team.TeamID]
+		if !found && !isPlannedTeamID(team.TeamID) {
+			notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "Failed to read team members: Team::" + team.TeamID + " not found in iTop"})
+			continue
 		}
-		if alreadyInTeam {
+		if hasMember(members, userID) {
 			successSyncedW.Write([]string{user.CN, user.Email, team.TeamID, "Already in team (sync ke department: " + team.DeptName + ")"})
 			continue
 		}
-		personsList = append(personsList, map[string]interface{}{
-			"person_id": userID,
-			"role_id":   "0",
-		})
-		updateResp, err := client.Post("core/update", map[string]interface{}{
-			"class":   "Team",
-			"key":     team.TeamID,
-			"comment": fmt.Sprintf("Menambahkan Person::%s (%s) ke Team::%s", userID, user.CN, team.TeamID),
-			"fields": map[string]interface{}{
-				"persons_list": personsList,
-			},
-		})
-		if err != nil {
-			notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "Failed to add to team: " + err.Error()})
-			continue
+		// Count the user as a member right away so a Person reached through two
+		// logins is only added once
+		teamMembers[team.TeamID] = append(members, itopclient.TeamMember{PersonID: userID, RoleID: "0"})
+		additions[team.TeamID] = append(additions[team.TeamID], pendingAdd{user: user, personID: userID})
+		teamNames[team.TeamID] = team.DeptName
+	}
+
+	changedTeams := make([]string, 0, len(additions))
+	for teamID := range additions {
+		changedTeams = append(changedTeams, teamID)
+	}
+	sort.Strings(changedTeams)
+	if plan != nil {
+		for _, teamID := range changedTeams {
+			deptName := teamNames[teamID]
+			for _, a := range additions[teamID] {
+				plan.addMembership(PlannedMembership{
+					CN:             a.user.CN,
+					Email:          a.user.Email,
+					SAMAccountName: a.user.SAMAccountName,
+					PersonID:       a.personID,
+					TeamID:         teamID,
+					DepartmentName: deptName,
+				})
+				successSyncedW.Write([]string{a.user.CN, a.user.Email, teamID, "Would be added to team (dry-run, department: " + deptName + ")"})
+			}
 		}
-		var updateMap map[string]interface{}
-		if err := json.Unmarshal(updateResp, &updateMap); err != nil {
-			notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, "Failed to parse update response: " + err.Error()})
-			continue
+	} else {
+		type updateResult struct {
+			updated []itopclient.TeamMember
+			err     error
 		}
-		code, _ := updateMap["code"].(float64)
-		msg, _ := updateMap["message"].(string)
-		objects, _ := updateMap["objects"].(map[string]interface{})
-		teamKey := "Team::" + team.TeamID
-		teamObj, ok := objects[teamKey].(map[string]interface{})
-		if code == 0 && ok {
-			fields, _ := teamObj["fields"].(map[string]interface{})
-			if pl, ok := fields["persons_list"].([]interface{}); ok {
-				found := false
-				for _, p := range pl {
-					if pm, ok := p.(map[string]interface{}); ok {
-						if fmt.Sprintf("%v", pm["person_id"]) == userID {
-							found = true
-							break
-						}
-					}
-				}
-				if found {
-					successSyncedW.Write([]string{user.CN, user.Email, team.TeamID, "Successfully added to team (sync ke department: " + team.DeptName + ")"})
-					continue
+		results := make([]updateResult, len(changedTeams))
+		forEachTeam(changedTeams, opts.Workers, func(i int, teamID string) {
+			updated, err := client.UpdateTeamMembers(teamID, teamMembers[teamID],
+				fmt.Sprintf("Menambahkan %d anggota ke Team::%s (sync ke department: %s)", len(additions[teamID]), teamID, teamNames[teamID]))
+			results[i] = updateResult{updated: updated, err: err}
+			if err == nil {
+				log.Printf("[OK] Added %d member(s) to Team::%s (%s)", len(additions[teamID]), teamID, teamNames[teamID])
+			}
+		})
+		// Reports are written here, in team order, since csv.Writer is not safe for concurrent use
+		for i, teamID := range changedTeams {
+			res := results[i]
+			deptName := teamNames[teamID]
+			for _, a := range additions[teamID] {
+				switch {
+				case res.err != nil:
+					notSyncedW.Write([]string{a.user.CN, a.user.Email, a.user.SAMAccountName, "Failed to add to team: " + res.err.Error()})
+				case !hasMember(res.updated, a.personID):
+					notSyncedW.Write([]string{a.user.CN, a.user.Email, a.user.SAMAccountName, "Failed to add to team: user not present in persons_list after update"})
+				default:
+					metrics.MembershipsAdded.Inc()
+					successSyncedW.Write([]string{a.user.CN, a.user.Email, teamID, "Successfully added to team (sync ke department: " + deptName + ")"})
 				}
 			}
 		}
-		failMsg := "Failed to add to team: "
-		if msg != "" {
-			failMsg += msg
-		} else {
-			failMsg += "unknown error or user not present in persons_list after update"
+	}
+
+	if opts.Reconcile {
+		// Excluded users are never removed from any team
+		protected := make(map[string]bool)
+		for _, user := range users {
+			if id := contactIDs[strings.ToLower(user.SAMAccountName)]; excludeMap[user.CN] && id != "" {
+				protected[id] = true
+			}
+		}
+		managed := make(map[string]string) // TeamID -> DepartmentName
+		for _, t := range teamMap {
+			if !isPlannedTeamID(t.TeamID) {
+				managed[t.TeamID] = t.DeptName
+			}
+		}
+		if err := reconcileTeamMembers(client, managed, desired, protected, opts, plan); err != nil {
+			return err
 		}
-		notSyncedW.Write([]string{user.CN, user.Email, user.SAMAccountName, failMsg})
 	}
 
 	return nil
 }
+
+// excludeSet turns the configured exclusion list into a set of CNs
+func excludeSet(cns []string) map[string]bool {
+	excludeMap := make(map[string]bool)
+	for _, cn := range cns {
+		if trimmed := strings.TrimSpace(cn); trimmed != "" {
+			excludeMap[trimmed] = true
+		}
+	}
+	return excludeMap
+}
+
+func hasMember(members []itopclient.TeamMember, personID string) bool {
+	for _, m := range members {
+		if m.PersonID == personID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package synchronizer
+
+import "sync"
+
+// teamLocks serialises updates per Team. persons_list updates replace the whole
+// list, so two concurrent updates of one Team would drop each other's changes.
+var teamLocks keyedMutex
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*sync.Mutex)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		k.locks[key] = l
+	}
+	k.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// forEachTeam calls fn(i, teamIDs[i]) for every team using up to workers goroutines
+// and returns when all calls are done. fn runs while holding that team's lock.
+func forEachTeam(teamIDs []string, workers int, fn func(i int, teamID string)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(teamIDs) {
+		workers = len(teamIDs)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				unlock := teamLocks.lock(teamIDs[i])
+				fn(i, teamIDs[i])
+				unlock()
+			}
+		}()
+	}
+	for i := range teamIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}